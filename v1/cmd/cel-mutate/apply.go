@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/admission"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/api"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/manifest"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/openapi"
+)
+
+// runApply applies the matching policies, in order, to every manifest and
+// writes all manifests, mutated or not, to stdout.
+func runApply(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("apply", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	policyPath := flags.String("p", "", "file or directory of MutatingAdmissionPolicy manifests")
+	manifestPath := flags.String("f", "-", "file of manifests to mutate, or - for stdin")
+	operation := flags.String("operation", string(api.Create), "operation of the admission requests to match policies against")
+	openAPIPath := flags.String("openapi", "", "OpenAPI v3 document or CustomResourceDefinition file, or directory of them, with the schemas of the manifests by kind")
+	maxReinvocations := flags.Int("max-reinvocations", admission.DefaultMaxReinvocations, "maximum rounds of reinvocation of policies with reinvocationPolicy: IfNeeded")
+	verbose := flags.Bool("v", false, "report the policies applied to each manifest, and the fields they take over from other managers, to stderr")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *policyPath == "" {
+		flags.Usage()
+		return errors.New("missing -p")
+	}
+	loaded, err := api.LoadPolicyFiles(*policyPath)
+	if err != nil {
+		return err
+	}
+	// policies are compiled once for each kind, against the schema of the
+	// kind if the document has one.
+	var schemaOf admission.SchemaFunc
+	if *openAPIPath != "" {
+		document, err := openapi.LoadDocumentFiles(*openAPIPath)
+		if err != nil {
+			return err
+		}
+		schemaOf = document.SchemaOf
+	}
+	policies, err := admission.NewKindPolicies(loaded, schemaOf, admission.WithMaxReinvocations(*maxReinvocations))
+	if err != nil {
+		return err
+	}
+	f, err := open(*manifestPath, stdin)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	objects, err := manifest.Read(f)
+	if err != nil {
+		return err
+	}
+	op := api.OperationType(strings.ToUpper(*operation))
+	for _, object := range objects {
+		p, err := policies.For(object.GroupVersionKind())
+		if err != nil {
+			return fmt.Errorf("%s %q: %w", object.GetKind(), object.GetName(), err)
+		}
+		result, err := p.Admit(object.Object, manifest.AttributesOf(object, op))
+		if err != nil {
+			return fmt.Errorf("%s %q: %w", object.GetKind(), object.GetName(), err)
+		}
+		object.Object = result.Object
+		if *verbose {
+			for _, name := range result.Applied {
+				fmt.Fprintf(stderr, "%s %q: applied policy %q\n", object.GetKind(), object.GetName(), name)
+			}
+			if result.Reinvocations > 0 {
+				fmt.Fprintf(stderr, "%s %q: reinvoked policies %d time(s)\n", object.GetKind(), object.GetName(), result.Reinvocations)
+			}
+			for _, c := range result.Conflicts {
+				fmt.Fprintf(stderr, "%s %q: %s\n", object.GetKind(), object.GetName(), c)
+			}
+		}
+	}
+	return manifest.WriteYAML(stdout, objects)
+}
@@ -0,0 +1,48 @@
+// Command cel-mutate evaluates MutatingAdmissionPolicies offline, e.g. to
+// preview mutations in CI before anything reaches a cluster.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `Usage: cel-mutate <command> [flags]
+
+Commands:
+  apply    apply policies to manifests and write the mutated manifests
+  test     run golden-file tests of policies
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch command, args := os.Args[1], os.Args[2:]; command {
+	case "apply":
+		err = runApply(args, os.Stdin, os.Stdout, os.Stderr)
+	case "test":
+		err = runTest(args, os.Stdout, os.Stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n%s", command, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cel-mutate %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// open opens the file, where "-" stands for stdin.
+func open(path string, stdin io.Reader) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(stdin), nil
+	}
+	return os.Open(path)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/api"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/golden"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/openapi"
+)
+
+// runTest runs the golden-file test cases under the given directories, the
+// current directory by default.
+func runTest(args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	update := flags.Bool("update", false, "rewrite "+golden.ExpectedFileName+" of the failed cases with the actual output")
+	schemaPath := flags.String("schema", "", "OpenAPI schema of the inputs, for cases without their own")
+	openAPIPath := flags.String("openapi", "", "OpenAPI v3 document or CustomResourceDefinition file, or directory of them, with the schemas of the inputs by kind, for cases without their own")
+	operation := flags.String("operation", string(api.Create), "operation of the admission requests to match policies against")
+	idempotency := flags.Bool("idempotency", false, "also fail the cases of which the policies change the inputs again when reinvoked")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	opts := []golden.Option{
+		golden.WithUpdate(*update),
+		golden.WithOperation(api.OperationType(strings.ToUpper(*operation))),
+		golden.WithIdempotencyCheck(*idempotency),
+	}
+	if *schemaPath != "" {
+		f, err := os.Open(*schemaPath)
+		if err != nil {
+			return err
+		}
+		schema, err := openapi.LoadSchema(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", *schemaPath, err)
+		}
+		opts = append(opts, golden.WithSchema(schema))
+	}
+	if *openAPIPath != "" {
+		document, err := openapi.LoadDocumentFiles(*openAPIPath)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, golden.WithSchemas(document.SchemaOf))
+	}
+	runner := golden.NewRunner(opts...)
+
+	roots := flags.Args()
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	failed := 0
+	for _, root := range roots {
+		cases, err := golden.Discover(root)
+		if err != nil {
+			return err
+		}
+		for _, c := range cases {
+			result, err := runner.Run(c)
+			switch {
+			case err != nil:
+				failed++
+				fmt.Fprintf(stdout, "FAIL\t%s\n\t%v\n", c.Dir, err)
+			case !result.Passed():
+				failed++
+				fmt.Fprintf(stdout, "FAIL\t%s\n%s", c.Dir, result.Diff)
+				for _, n := range result.NonIdempotent {
+					fmt.Fprintf(stdout, "\tnot idempotent: %s\n", n)
+				}
+			case result.Updated:
+				fmt.Fprintf(stdout, "UPDATE\t%s\n", c.Dir)
+			default:
+				fmt.Fprintf(stdout, "ok\t%s\n", c.Dir)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d failed", failed)
+	}
+	return nil
+}
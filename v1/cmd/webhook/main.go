@@ -0,0 +1,51 @@
+// Command webhook serves MutatingAdmissionPolicies as a mutating admission
+// webhook, for clusters without native support of the policies.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/api"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/openapi"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/webhook"
+)
+
+func main() {
+	addr := flag.String("addr", ":8443", "address to listen on")
+	certFile := flag.String("tls-cert-file", "", "file containing the x509 certificate for HTTPS")
+	keyFile := flag.String("tls-private-key-file", "", "file containing the x509 private key matching --tls-cert-file")
+	policyPath := flag.String("policies", "", "file or directory of MutatingAdmissionPolicy manifests")
+	openAPIPath := flag.String("openapi", "", "OpenAPI v3 document or CustomResourceDefinition file, or directory of them, with the schemas of the objects by kind")
+	flag.Parse()
+
+	if *certFile == "" || *keyFile == "" || *policyPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	policies, err := api.LoadPolicyFiles(*policyPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var opts []webhook.Option
+	if *openAPIPath != "" {
+		document, err := openapi.LoadDocumentFiles(*openAPIPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, webhook.WithSchemas(document.SchemaOf))
+	}
+	w, err := webhook.NewWebhook(policies, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/mutate", w)
+	mux.HandleFunc("/healthz", func(rw http.ResponseWriter, _ *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+	log.Printf("serving %d policies on %s", len(policies), *addr)
+	log.Fatal(http.ListenAndServeTLS(*addr, *certFile, *keyFile, mux))
+}
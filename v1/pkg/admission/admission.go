@@ -0,0 +1,187 @@
+// Package admission applies an ordered set of MutatingAdmissionPolicies to
+// objects, the way an admission chain does.
+package admission
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"sigs.k8s.io/structured-merge-diff/v4/merge"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/api"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/evaluator"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/matcher"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/mutator"
+)
+
+// Policies holds the compiled policies, in order.
+type Policies struct {
+	evaluators  []*evaluator.PolicyEvaluator
+	equivalents runtime.EquivalentResourceMapper
+	schema      *spec.Schema
+	matcher     *matcher.Matcher
+
+	maxReinvocations int
+}
+
+// DefaultMaxReinvocations is the number of times that policies with
+// reinvocationPolicy: IfNeeded can be reinvoked by default. Like mutating
+// webhooks, they are reinvoked at most once.
+const DefaultMaxReinvocations = 1
+
+// Result is the outcome of admitting an object.
+type Result struct {
+	// Object is the mutated object.
+	Object map[string]any
+
+	// Applied holds the names of the policies that have mutated the object,
+	// in order, including those that have been reinvoked.
+	Applied []string
+
+	// Reinvocations is the number of rounds in which policies have been
+	// reinvoked.
+	Reinvocations int
+
+	// Patch is the JSONPatch that turns the original object into the
+	// mutated one.
+	Patch mutator.JSONPatch
+
+	// Conflicts holds the fields that the applied policies have taken over
+	// from other field managers, in order. See evaluator.Result.
+	Conflicts []Conflict
+}
+
+// Conflict is a field that a policy has taken over from another field
+// manager.
+type Conflict struct {
+	Policy   string
+	Conflict merge.Conflict
+}
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("policy %q: %v", c.Policy, c.Conflict.Error())
+}
+
+// Option configures Policies.
+type Option func(*Policies)
+
+// WithEquivalentResources sets the mapper that finds equivalent resources
+// for policies with matchPolicy: Equivalent.
+func WithEquivalentResources(equivalents runtime.EquivalentResourceMapper) Option {
+	return func(p *Policies) {
+		p.equivalents = equivalents
+	}
+}
+
+// WithSchema compiles the policies against the schema of the objects they
+// mutate. See evaluator.WithSchema.
+func WithSchema(schema *spec.Schema) Option {
+	return func(p *Policies) {
+		p.schema = schema
+	}
+}
+
+// WithMaxReinvocations sets the number of rounds in which policies with
+// reinvocationPolicy: IfNeeded can be reinvoked. Defaults to
+// DefaultMaxReinvocations.
+func WithMaxReinvocations(n int) Option {
+	return func(p *Policies) {
+		p.maxReinvocations = n
+	}
+}
+
+// NewPolicies compiles the given policies.
+func NewPolicies(policies []*api.MutatingAdmissionPolicy, opts ...Option) (*Policies, error) {
+	p := &Policies{maxReinvocations: DefaultMaxReinvocations}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.matcher = matcher.NewMatcher(p.equivalents)
+	for _, policy := range policies {
+		e, err := evaluator.NewPolicyEvaluator(policy, evaluator.WithSchema(p.schema))
+		if err != nil {
+			return nil, fmt.Errorf("cannot compile policy %q: %w", policy.Name, err)
+		}
+		p.evaluators = append(p.evaluators, e)
+	}
+	return p, nil
+}
+
+// Admit applies the policies that match the attributes, in order, to a copy
+// of the object. A policy that fails with failure policy Ignore leaves the
+// object as if the policy has not run, because policies are all-or-nothing.
+// A policy that fails otherwise fails the admission. The given object is
+// never modified.
+//
+// Afterward, the policies with reinvocationPolicy: IfNeeded that have been
+// applied are reinvoked, in order, if the object has been changed since they
+// ran, until no such policy remains or the reinvocations reach the limit.
+// Reinvocation belongs to the chain rather than to PolicyEvaluator, because
+// whether a policy needs it depends on the changes of the policies after it.
+// See CheckIdempotency for the policies that reinvocation keeps changing.
+func (p *Policies) Admit(object map[string]any, attr *matcher.Attributes) (*Result, error) {
+	result := &Result{Object: runtime.DeepCopyJSON(object)}
+	// changes counts the policy runs that have changed the object, and
+	// ranAt holds the count when each applied policy last ran.
+	changes := 0
+	ranAt := make(map[int]int)
+	apply := func(i int) error {
+		e := p.evaluators[i]
+		policy := e.Policy()
+		r, err := e.Apply(result.Object)
+		if err != nil {
+			if failurePolicy := policy.Spec.FailurePolicy; failurePolicy != nil && *failurePolicy == api.Ignore {
+				return nil
+			}
+			return fmt.Errorf("policy %q: %w", policy.Name, err)
+		}
+		if len(r.Patch) > 0 {
+			changes++
+		}
+		ranAt[i] = changes
+		result.Applied = append(result.Applied, policy.Name)
+		result.Patch = append(result.Patch, r.Patch...)
+		for _, c := range r.Conflicts {
+			result.Conflicts = append(result.Conflicts, Conflict{Policy: policy.Name, Conflict: c})
+		}
+		return nil
+	}
+	for i, e := range p.evaluators {
+		policy := e.Policy()
+		matched, _, err := p.matcher.Matches(policy.Spec.MatchConstraints, attr)
+		if err != nil {
+			return nil, fmt.Errorf("policy %q: %w", policy.Name, err)
+		}
+		if !matched {
+			continue
+		}
+		if err := apply(i); err != nil {
+			return nil, err
+		}
+	}
+	for result.Reinvocations < p.maxReinvocations {
+		var reinvoked []int
+		for i, e := range p.evaluators {
+			ran, ok := ranAt[i]
+			if ok && ran < changes && reinvocable(e.Policy()) {
+				reinvoked = append(reinvoked, i)
+			}
+		}
+		if len(reinvoked) == 0 {
+			break
+		}
+		result.Reinvocations++
+		for _, i := range reinvoked {
+			if err := apply(i); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+func reinvocable(policy *api.MutatingAdmissionPolicy) bool {
+	r := policy.Spec.ReinvocationPolicy
+	return r != nil && *r == api.IfNeededReinvocationPolicy
+}
@@ -0,0 +1,254 @@
+package admission
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/api"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/matcher"
+)
+
+func loadPolicy(t *testing.T) *api.MutatingAdmissionPolicy {
+	policies, err := api.LoadPolicyFiles("../../testdata/listmerge/mutation.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policies[0]
+}
+
+func loadObject(t *testing.T) map[string]any {
+	b, err := os.ReadFile("../../testdata/listmerge/deploy.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	object := make(map[string]any)
+	if err := yaml.Unmarshal(b, &object); err != nil {
+		t.Fatal(err)
+	}
+	return object
+}
+
+func TestAdmit(t *testing.T) {
+	policy := loadPolicy(t)
+	failing := policy.DeepCopy()
+	failing.Name = "failing"
+	failing.Spec.Mutation[0].Expressions = []string{
+		`object.metadata.merge({"annotations": {"failing": "true"}})`,
+		"object.spec.strategy.remove()",
+	}
+	ignore := api.Ignore
+	ignored := failing.DeepCopy()
+	ignored.Name = "ignored"
+	ignored.Spec.FailurePolicy = &ignore
+
+	deployments := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	for _, tc := range []struct {
+		name            string
+		policies        []*api.MutatingAdmissionPolicy
+		resource        schema.GroupVersionResource
+		expectedApplied []string
+		expectedErr     bool
+	}{
+		{
+			name:            "applied",
+			policies:        []*api.MutatingAdmissionPolicy{policy},
+			resource:        deployments,
+			expectedApplied: []string{policy.Name},
+		},
+		{
+			name:     "not matched",
+			policies: []*api.MutatingAdmissionPolicy{policy},
+			resource: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"},
+		},
+		{
+			name:        "failed",
+			policies:    []*api.MutatingAdmissionPolicy{policy, failing},
+			resource:    deployments,
+			expectedErr: true,
+		},
+		{
+			name:            "ignored",
+			policies:        []*api.MutatingAdmissionPolicy{ignored, policy},
+			resource:        deployments,
+			expectedApplied: []string{policy.Name},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			policies, err := NewPolicies(tc.policies)
+			if err != nil {
+				t.Fatal(err)
+			}
+			object := loadObject(t)
+			result, err := policies.Admit(object, &matcher.Attributes{Resource: tc.resource, Operation: api.Create})
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(object, loadObject(t)) {
+				t.Errorf("unexpected modification of the given object: %v", object)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(result.Applied, tc.expectedApplied) {
+				t.Errorf("expected %v to be applied but got %v", tc.expectedApplied, result.Applied)
+			}
+			if annotations, ok := result.Object["metadata"].(map[string]any)["annotations"]; ok {
+				t.Errorf("unexpected changes of a failed policy: %v", annotations)
+			}
+			if len(tc.expectedApplied) > 0 == (len(result.Patch) == 0) {
+				t.Errorf("unexpected patch: %v", result.Patch)
+			}
+		})
+	}
+}
+
+func TestAdmitReinvocation(t *testing.T) {
+	newPolicy := func(name string, reinvocation api.ReinvocationPolicyType, expression string) *api.MutatingAdmissionPolicy {
+		policy := loadPolicy(t)
+		policy.Name = name
+		policy.Spec.ReinvocationPolicy = &reinvocation
+		policy.Spec.Mutation = []api.Mutation{{Expressions: []string{expression}}}
+		return policy
+	}
+	// label copies the replicas into a label, and scale changes the replicas
+	// afterward, so that label needs reinvocation.
+	label := func(reinvocation api.ReinvocationPolicyType) *api.MutatingAdmissionPolicy {
+		return newPolicy("label", reinvocation, `object.metadata.merge({"labels": {"replicas": string(object.spec.replicas)}})`)
+	}
+	scale := newPolicy("scale", api.NeverReinvocationPolicy, `object.spec.merge({"replicas": 5})`)
+	// appending to a list without a schema is never idempotent, so sidecarA
+	// and sidecarB keep needing reinvocation because of each other.
+	sidecarA := newPolicy("sidecar-a", api.IfNeededReinvocationPolicy, `object.spec.template.spec.containers.merge([{"name": "a"}])`)
+	sidecarB := newPolicy("sidecar-b", api.IfNeededReinvocationPolicy, `object.spec.template.spec.containers.merge([{"name": "b"}])`)
+	for _, tc := range []struct {
+		name                  string
+		policies              []*api.MutatingAdmissionPolicy
+		opts                  []Option
+		expectedApplied       []string
+		expectedReinvocations int
+		expectedLabel         string
+	}{
+		{
+			name:            "never",
+			policies:        []*api.MutatingAdmissionPolicy{label(api.NeverReinvocationPolicy), scale},
+			expectedApplied: []string{"label", "scale"},
+			expectedLabel:   "1",
+		},
+		{
+			name:                  "if needed",
+			policies:              []*api.MutatingAdmissionPolicy{label(api.IfNeededReinvocationPolicy), scale},
+			expectedApplied:       []string{"label", "scale", "label"},
+			expectedReinvocations: 1,
+			expectedLabel:         "5",
+		},
+		{
+			name:            "not needed",
+			policies:        []*api.MutatingAdmissionPolicy{scale, label(api.IfNeededReinvocationPolicy)},
+			expectedApplied: []string{"scale", "label"},
+			expectedLabel:   "5",
+		},
+		{
+			name:            "no reinvocation allowed",
+			policies:        []*api.MutatingAdmissionPolicy{label(api.IfNeededReinvocationPolicy), scale},
+			opts:            []Option{WithMaxReinvocations(0)},
+			expectedApplied: []string{"label", "scale"},
+			expectedLabel:   "1",
+		},
+		{
+			name:                  "bounded",
+			policies:              []*api.MutatingAdmissionPolicy{sidecarA, sidecarB},
+			opts:                  []Option{WithMaxReinvocations(3)},
+			expectedApplied:       []string{"sidecar-a", "sidecar-b", "sidecar-a", "sidecar-b", "sidecar-a"},
+			expectedReinvocations: 3,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			policies, err := NewPolicies(tc.policies, tc.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := policies.Admit(loadObject(t), &matcher.Attributes{
+				Resource:  schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+				Operation: api.Create,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result.Applied, tc.expectedApplied) {
+				t.Errorf("expected %v to be applied but got %v", tc.expectedApplied, result.Applied)
+			}
+			if result.Reinvocations != tc.expectedReinvocations {
+				t.Errorf("expected %d reinvocations but got %d", tc.expectedReinvocations, result.Reinvocations)
+			}
+			if tc.expectedLabel == "" {
+				return
+			}
+			labels := result.Object["metadata"].(map[string]any)["labels"].(map[string]any)
+			if labels["replicas"] != tc.expectedLabel {
+				t.Errorf("expected label %q but got %v", tc.expectedLabel, labels["replicas"])
+			}
+		})
+	}
+}
+
+func TestAdmitConflicts(t *testing.T) {
+	policies, err := api.LoadPolicyFiles("../../testdata/managedfields/mutation.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPolicies(policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile("../../testdata/managedfields/deploy.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	object := make(map[string]any)
+	if err := yaml.Unmarshal(b, &object); err != nil {
+		t.Fatal(err)
+	}
+	result, err := p.Admit(object, &matcher.Attributes{
+		Resource:  schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+		Operation: api.Update,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Conflicts) != 1 {
+		t.Fatalf("expected 1 conflict but got %v", result.Conflicts)
+	}
+	if c := result.Conflicts[0]; c.Policy != policies[0].Name || c.Conflict.Manager != "kubectl" || c.Conflict.Path.String() != ".spec.replicas" {
+		t.Errorf("unexpected conflict: %v", c)
+	}
+}
+
+func TestCheckIdempotency(t *testing.T) {
+	sidecar := loadPolicy(t)
+	replicas := sidecar.DeepCopy()
+	replicas.Name = "replicas"
+	replicas.Spec.Mutation[0].Expressions = []string{`object.spec.merge({"replicas": 5})`}
+	policies, err := NewPolicies([]*api.MutatingAdmissionPolicy{replicas, sidecar})
+	if err != nil {
+		t.Fatal(err)
+	}
+	object := loadObject(t)
+	nonIdempotent, err := policies.CheckIdempotency(object, &matcher.Attributes{
+		Resource:  schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+		Operation: api.Create,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// appending to a list without a schema is not idempotent.
+	if len(nonIdempotent) != 1 || nonIdempotent[0].Name != sidecar.Name || len(nonIdempotent[0].Expressions) != 1 {
+		t.Errorf("expected only %q to be reported, but got %v", sidecar.Name, nonIdempotent)
+	}
+	if !reflect.DeepEqual(object, loadObject(t)) {
+		t.Errorf("unexpected changes of the object: %v", object)
+	}
+}
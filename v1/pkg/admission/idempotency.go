@@ -0,0 +1,56 @@
+package admission
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/api"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/evaluator"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/matcher"
+)
+
+// NonIdempotentPolicy is a policy that changes the object again when it is
+// reinvoked.
+type NonIdempotentPolicy struct {
+	Name string
+
+	// Expressions holds the expressions that change the object again.
+	Expressions []*evaluator.NonIdempotentExpression
+}
+
+// CheckIdempotency checks the policies that match the attributes, in order,
+// and returns those that change the object again when they are reinvoked.
+// Each policy is checked against the object as it is passed to the policy
+// during admission, i.e. as mutated by the policies before it. See
+// evaluator.PolicyEvaluator.CheckIdempotency. A policy that fails with
+// failure policy Ignore is skipped, like in Admit. The given object is never
+// modified.
+func (p *Policies) CheckIdempotency(object map[string]any, attr *matcher.Attributes) ([]*NonIdempotentPolicy, error) {
+	object = runtime.DeepCopyJSON(object)
+	var nonIdempotent []*NonIdempotentPolicy
+	for _, e := range p.evaluators {
+		policy := e.Policy()
+		matched, _, err := p.matcher.Matches(policy.Spec.MatchConstraints, attr)
+		if err != nil {
+			return nil, fmt.Errorf("policy %q: %w", policy.Name, err)
+		}
+		if !matched {
+			continue
+		}
+		expressions, err := e.CheckIdempotency(object)
+		if err == nil {
+			_, err = e.Apply(object)
+		}
+		if err != nil {
+			if failurePolicy := policy.Spec.FailurePolicy; failurePolicy != nil && *failurePolicy == api.Ignore {
+				continue
+			}
+			return nil, fmt.Errorf("policy %q: %w", policy.Name, err)
+		}
+		if len(expressions) > 0 {
+			nonIdempotent = append(nonIdempotent, &NonIdempotentPolicy{Name: policy.Name, Expressions: expressions})
+		}
+	}
+	return nonIdempotent, nil
+}
@@ -0,0 +1,71 @@
+package admission
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/api"
+)
+
+// SchemaFunc returns the schema of the objects of the kind, or nil if the
+// kind has no known schema.
+type SchemaFunc func(gvk schema.GroupVersionKind) (*spec.Schema, error)
+
+// KindPolicies compiles the policies once for each kind of objects, against
+// the schema of the kind. It is safe for concurrent use.
+type KindPolicies struct {
+	policies []*api.MutatingAdmissionPolicy
+	schemaOf SchemaFunc
+	opts     []Option
+
+	// schemaless holds the policies compiled without a schema, for the
+	// kinds without one.
+	schemaless *Policies
+
+	mu       sync.Mutex
+	compiled map[schema.GroupVersionKind]*Policies
+}
+
+// NewKindPolicies compiles the given policies without a schema right away,
+// so that policies that do not compile are reported early. The options
+// apply to the policies of all kinds, and must not include WithSchema.
+func NewKindPolicies(policies []*api.MutatingAdmissionPolicy, schemaOf SchemaFunc, opts ...Option) (*KindPolicies, error) {
+	schemaless, err := NewPolicies(policies, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &KindPolicies{
+		policies:   policies,
+		schemaOf:   schemaOf,
+		opts:       opts,
+		schemaless: schemaless,
+		compiled:   make(map[schema.GroupVersionKind]*Policies),
+	}, nil
+}
+
+// For returns the policies compiled for the kind.
+func (k *KindPolicies) For(gvk schema.GroupVersionKind) (*Policies, error) {
+	if k.schemaOf == nil {
+		return k.schemaless, nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if p, ok := k.compiled[gvk]; ok {
+		return p, nil
+	}
+	s, err := k.schemaOf(gvk)
+	if err != nil {
+		return nil, err
+	}
+	p := k.schemaless
+	if s != nil {
+		opts := append(append([]Option{}, k.opts...), WithSchema(s))
+		if p, err = NewPolicies(k.policies, opts...); err != nil {
+			return nil, err
+		}
+	}
+	k.compiled[gvk] = p
+	return p, nil
+}
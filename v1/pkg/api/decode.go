@@ -0,0 +1,110 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// scheme holds the types of the package with their defaulting functions.
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(AddToScheme(scheme))
+}
+
+// LoadPolicies decodes all MutatingAdmissionPolicies from a stream of YAML
+// documents or JSON objects, with defaults applied. Empty documents are
+// skipped. Any other document must be a MutatingAdmissionPolicy without
+// unknown fields, so that a misspelled field is reported instead of being
+// dropped.
+func LoadPolicies(reader io.Reader) ([]*MutatingAdmissionPolicy, error) {
+	var policies []*MutatingAdmissionPolicy
+	d := yaml.NewYAMLOrJSONDecoder(reader, 4096)
+	for i := 0; ; i++ {
+		var object map[string]any
+		err := d.Decode(&object)
+		if errors.Is(err, io.EOF) {
+			return policies, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode policy: %w", err)
+		}
+		if len(object) == 0 {
+			continue
+		}
+		policy, err := decodePolicy(object)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		scheme.Default(policy)
+		policies = append(policies, policy)
+	}
+}
+
+// decodePolicy decodes a MutatingAdmissionPolicy strictly.
+func decodePolicy(object map[string]any) (*MutatingAdmissionPolicy, error) {
+	b, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	policy := new(MutatingAdmissionPolicy)
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(policy); err != nil {
+		return nil, fmt.Errorf("cannot decode policy: %w", err)
+	}
+	if gvk := policy.GroupVersionKind(); gvk != SchemeGroupVersion.WithKind("MutatingAdmissionPolicy") {
+		return nil, fmt.Errorf("unexpected kind %v of policy %q", gvk, policy.Name)
+	}
+	return policy, nil
+}
+
+// LoadPolicyFiles loads policies from a file, or from all YAML and JSON
+// files in a directory, in lexical order.
+func LoadPolicyFiles(path string) ([]*MutatingAdmissionPolicy, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		files = files[:0]
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			switch strings.ToLower(filepath.Ext(entry.Name())) {
+			case ".yaml", ".yml", ".json":
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+	var policies []*MutatingAdmissionPolicy
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		loaded, err := LoadPolicies(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		policies = append(policies, loaded...)
+	}
+	return policies, nil
+}
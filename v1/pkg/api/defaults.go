@@ -0,0 +1,53 @@
+package api
+
+import (
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SetObjectDefaults_MutatingAdmissionPolicy fills in the defaults of all
+// optional fields of the given policy, following that of
+// ValidatingAdmissionPolicy.
+func SetObjectDefaults_MutatingAdmissionPolicy(in *MutatingAdmissionPolicy) {
+	SetDefaults_MutatingAdmissionPolicySpec(&in.Spec)
+	if in.Spec.MatchConstraints != nil {
+		SetDefaults_MatchResources(in.Spec.MatchConstraints)
+	}
+}
+
+func SetDefaults_MutatingAdmissionPolicySpec(obj *MutatingAdmissionPolicySpec) {
+	if obj.FailurePolicy == nil {
+		policy := Fail
+		obj.FailurePolicy = &policy
+	}
+	if obj.ReinvocationPolicy == nil {
+		policy := NeverReinvocationPolicy
+		obj.ReinvocationPolicy = &policy
+	}
+}
+
+func SetDefaults_MatchResources(obj *MatchResources) {
+	if obj.MatchPolicy == nil {
+		policy := Equivalent
+		obj.MatchPolicy = &policy
+	}
+	if obj.NamespaceSelector == nil {
+		obj.NamespaceSelector = &metav1.LabelSelector{}
+	}
+	if obj.ObjectSelector == nil {
+		obj.ObjectSelector = &metav1.LabelSelector{}
+	}
+	for i := range obj.ResourceRules {
+		setDefaultsRuleScope(&obj.ResourceRules[i])
+	}
+	for i := range obj.ExcludeResourceRules {
+		setDefaultsRuleScope(&obj.ExcludeResourceRules[i])
+	}
+}
+
+func setDefaultsRuleScope(obj *NamedRuleWithOperations) {
+	if obj.Scope == nil {
+		scope := admissionregistrationv1.AllScopes
+		obj.Scope = &scope
+	}
+}
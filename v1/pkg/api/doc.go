@@ -0,0 +1,2 @@
+// Package api contains the types of MutatingAdmissionPolicy.
+package api
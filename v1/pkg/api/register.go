@@ -0,0 +1,38 @@
+package api
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const GroupName = "admissionregistration.k8s.io"
+
+var SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1alpha1"}
+
+var (
+	SchemeBuilder = runtime.NewSchemeBuilder(addKnownTypes, addDefaultingFuncs)
+	AddToScheme   = SchemeBuilder.AddToScheme
+)
+
+func addKnownTypes(scheme *runtime.Scheme) error {
+	scheme.AddKnownTypes(SchemeGroupVersion,
+		&MutatingAdmissionPolicy{},
+		&MutatingAdmissionPolicyList{},
+	)
+	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
+	return nil
+}
+
+func addDefaultingFuncs(scheme *runtime.Scheme) error {
+	scheme.AddTypeDefaultingFunc(&MutatingAdmissionPolicy{}, func(obj any) {
+		SetObjectDefaults_MutatingAdmissionPolicy(obj.(*MutatingAdmissionPolicy))
+	})
+	scheme.AddTypeDefaultingFunc(&MutatingAdmissionPolicyList{}, func(obj any) {
+		list := obj.(*MutatingAdmissionPolicyList)
+		for i := range list.Items {
+			SetObjectDefaults_MutatingAdmissionPolicy(&list.Items[i])
+		}
+	})
+	return nil
+}
@@ -1,9 +1,110 @@
 package api
 
+import (
+	"encoding/json"
+	"strconv"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	admissionregistrationv1alpha1 "k8s.io/api/admissionregistration/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MatchResources, ParamKind, Variable and the policy enums are shared with
+// ValidatingAdmissionPolicy, reuse them instead of duplicating.
+type (
+	MatchResources          = admissionregistrationv1alpha1.MatchResources
+	NamedRuleWithOperations = admissionregistrationv1alpha1.NamedRuleWithOperations
+	ParamKind               = admissionregistrationv1alpha1.ParamKind
+	Variable                = admissionregistrationv1alpha1.Variable
+	FailurePolicyType       = admissionregistrationv1alpha1.FailurePolicyType
+	MatchPolicyType         = admissionregistrationv1alpha1.MatchPolicyType
+	OperationType           = admissionregistrationv1alpha1.OperationType
+	ReinvocationPolicyType  = admissionregistrationv1.ReinvocationPolicyType
+)
+
+const (
+	Ignore = admissionregistrationv1alpha1.Ignore
+	Fail   = admissionregistrationv1alpha1.Fail
+
+	Exact      = admissionregistrationv1alpha1.Exact
+	Equivalent = admissionregistrationv1alpha1.Equivalent
+
+	OperationAll = admissionregistrationv1.OperationAll
+	Create       = admissionregistrationv1.Create
+	Update       = admissionregistrationv1.Update
+	Delete       = admissionregistrationv1.Delete
+	Connect      = admissionregistrationv1.Connect
+
+	NeverReinvocationPolicy    = admissionregistrationv1.NeverReinvocationPolicy
+	IfNeededReinvocationPolicy = admissionregistrationv1.IfNeededReinvocationPolicy
+)
+
+// MutatingAdmissionPolicy describes a set of CEL mutations that are applied
+// to matching objects.
 type MutatingAdmissionPolicy struct {
-	Spec struct {
-		Mutation []struct {
-			Expressions []string `json:"expressions"`
-		} `json:"mutation"`
-	} `json:"spec"`
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec MutatingAdmissionPolicySpec `json:"spec"`
+}
+
+// MutatingAdmissionPolicyList is a list of MutatingAdmissionPolicy.
+type MutatingAdmissionPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []MutatingAdmissionPolicy `json:"items"`
+}
+
+type MutatingAdmissionPolicySpec struct {
+	// ParamKind specifies the kind of resources used to parameterize this policy.
+	ParamKind *ParamKind `json:"paramKind,omitempty"`
+
+	// MatchConstraints specifies what resources this policy is designed to mutate.
+	MatchConstraints *MatchResources `json:"matchConstraints,omitempty"`
+
+	// Variables are evaluated before mutations and are available as
+	// variables.<name> to all expressions of the policy.
+	Variables []Variable `json:"variables,omitempty"`
+
+	// FailurePolicy defines how to handle failures of the policy. Defaults to Fail.
+	FailurePolicy *FailurePolicyType `json:"failurePolicy,omitempty"`
+
+	// ReinvocationPolicy indicates whether the policy is applied again if
+	// later policies change the object after it has been applied, following
+	// that of mutating webhooks. Defaults to Never.
+	ReinvocationPolicy *ReinvocationPolicyType `json:"reinvocationPolicy,omitempty"`
+
+	// Mutation is a list of mutation blocks, evaluated in order.
+	Mutation []Mutation `json:"mutation"`
+}
+
+// Mutation is a block of mutation expressions sharing the same condition.
+type Mutation struct {
+	// Condition is a CEL expression that must evaluate to a boolean.
+	// Expressions of this block only run if the condition is true.
+	// An empty condition is always true.
+	Condition Condition `json:"condition,omitempty"`
+
+	// Expressions are CEL expressions that mutate the object, in order.
+	Expressions []string `json:"expressions"`
+}
+
+// Condition is a CEL expression that evaluates to a boolean.
+// For convenience, a YAML/JSON boolean literal is accepted in place of
+// the expression and is treated as the literal CEL expression.
+type Condition string
+
+func (c *Condition) UnmarshalJSON(b []byte) error {
+	var literal bool
+	if err := json.Unmarshal(b, &literal); err == nil {
+		*c = Condition(strconv.FormatBool(literal))
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	*c = Condition(s)
+	return nil
 }
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+func TestDecodePolicy(t *testing.T) {
+	b, err := os.ReadFile("../../testdata/listmerge/mutation.yaml")
+	if err != nil {
+		t.Fatalf("cannot load policy file: %v", err)
+	}
+	policy := new(MutatingAdmissionPolicy)
+	if err := yaml.Unmarshal(b, policy); err != nil {
+		t.Fatalf("cannot decode policy: %v", err)
+	}
+	SetObjectDefaults_MutatingAdmissionPolicy(policy)
+	if policy.Kind != "MutatingAdmissionPolicy" || policy.APIVersion != SchemeGroupVersion.String() {
+		t.Errorf("unexpected type meta: %v", policy.TypeMeta)
+	}
+	if policy.Name != "set-rolling-upgrade.policy.example.com" {
+		t.Errorf("unexpected name: %q", policy.Name)
+	}
+	if *policy.Spec.FailurePolicy != Fail {
+		t.Errorf("unexpected failure policy: %v", *policy.Spec.FailurePolicy)
+	}
+	if *policy.Spec.ReinvocationPolicy != NeverReinvocationPolicy {
+		t.Errorf("unexpected reinvocation policy: %v", *policy.Spec.ReinvocationPolicy)
+	}
+	rules := policy.Spec.MatchConstraints.ResourceRules
+	if len(rules) != 1 || rules[0].Resources[0] != "deployments" || *rules[0].Scope != "*" {
+		t.Errorf("unexpected resource rules: %v", rules)
+	}
+	if *policy.Spec.MatchConstraints.MatchPolicy != Equivalent {
+		t.Errorf("unexpected match policy: %v", *policy.Spec.MatchConstraints.MatchPolicy)
+	}
+	if len(policy.Spec.Mutation) != 1 || policy.Spec.Mutation[0].Condition != "true" {
+		t.Errorf("unexpected mutation: %v", policy.Spec.Mutation)
+	}
+	copied := policy.DeepCopy()
+	copied.Spec.Mutation[0].Expressions[0] = "changed"
+	if policy.Spec.Mutation[0].Expressions[0] == "changed" {
+		t.Errorf("deep copy shares expressions with the original")
+	}
+}
+
+func TestConditionUnmarshal(t *testing.T) {
+	for _, tc := range []struct {
+		input    string
+		expected Condition
+	}{
+		{input: `{"condition": true}`, expected: "true"},
+		{input: `{"condition": false}`, expected: "false"},
+		{input: `{"condition": "object.spec.replicas < 3"}`, expected: "object.spec.replicas < 3"},
+		{input: `{}`, expected: ""},
+	} {
+		m := new(Mutation)
+		if err := yaml.Unmarshal([]byte(tc.input), m); err != nil {
+			t.Fatalf("%s: %v", tc.input, err)
+		}
+		if m.Condition != tc.expected {
+			t.Errorf("%s: expected %q but got %q", tc.input, tc.expected, m.Condition)
+		}
+	}
+}
+
+func TestLoadPolicies(t *testing.T) {
+	policies, err := LoadPolicies(strings.NewReader(`
+apiVersion: admissionregistration.k8s.io/v1alpha1
+kind: MutatingAdmissionPolicy
+metadata:
+  name: first
+spec:
+  mutation:
+  - expressions: ["object.spec.merge({})"]
+---
+---
+{"apiVersion": "admissionregistration.k8s.io/v1alpha1", "kind": "MutatingAdmissionPolicy", "metadata": {"name": "second"}}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 2 || policies[0].Name != "first" || policies[1].Name != "second" {
+		t.Fatalf("unexpected policies: %v", policies)
+	}
+	if policies[1].Spec.FailurePolicy == nil {
+		t.Errorf("expected defaults to be applied")
+	}
+	for _, document := range []string{
+		"apiVersion: apps/v1\nkind: Deployment\n",
+		"metadata:\n  name: untyped\n",
+		"apiVersion: admissionregistration.k8s.io/v1alpha1\nkind: MutatingAdmissionPolicy\nspec:\n  mutations: []\n",
+	} {
+		if _, err := LoadPolicies(strings.NewReader(document)); err == nil {
+			t.Errorf("expected error for %q", document)
+		}
+	}
+}
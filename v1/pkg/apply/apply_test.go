@@ -1,4 +1,4 @@
-package apply
+package apply_test
 
 import (
 	"fmt"
@@ -10,6 +10,7 @@ import (
 	"k8s.io/utils/ptr"
 	"sigs.k8s.io/structured-merge-diff/v4/typed"
 
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/apply"
 	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/openapi"
 )
 
@@ -99,5 +100,5 @@ func loadType() (*typed.ParseableType, error) {
 	if err != nil {
 		return nil, fmt.Errorf("cannot load schema: %w", err)
 	}
-	return CreateObjectType(s)
+	return apply.CreateObjectType(s)
 }
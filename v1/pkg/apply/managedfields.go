@@ -0,0 +1,128 @@
+package apply
+
+import (
+	"bytes"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+	"sigs.k8s.io/structured-merge-diff/v4/merge"
+	"sigs.k8s.io/structured-merge-diff/v4/typed"
+)
+
+// ManagedFields are the field managers of an object, decoded from its
+// metadata.managedFields.
+type ManagedFields struct {
+	entries []metav1.ManagedFieldsEntry
+	sets    []*fieldpath.Set
+}
+
+// ManagedFieldsOf decodes the managedFields of the object.
+func ManagedFieldsOf(object map[string]any) (*ManagedFields, error) {
+	raw, _, err := unstructured.NestedSlice(object, "metadata", "managedFields")
+	if err != nil {
+		return nil, err
+	}
+	m := &ManagedFields{}
+	for i, r := range raw {
+		u, ok := r.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("managedFields[%d]: not an object", i)
+		}
+		var entry metav1.ManagedFieldsEntry
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u, &entry); err != nil {
+			return nil, fmt.Errorf("managedFields[%d]: %w", i, err)
+		}
+		set := fieldpath.NewSet()
+		if entry.FieldsV1 != nil {
+			if err := set.FromJSON(bytes.NewReader(entry.FieldsV1.Raw)); err != nil {
+				return nil, fmt.Errorf("managedFields[%d]: %w", i, err)
+			}
+		}
+		m.entries = append(m.entries, entry)
+		m.sets = append(m.sets, set)
+	}
+	return m, nil
+}
+
+// Conflicts returns the fields that the manager has applied and changed,
+// but that are owned by other managers.
+func (m *ManagedFields) Conflicts(manager string, applied, changed *fieldpath.Set) merge.Conflicts {
+	owners := make(fieldpath.ManagedFields)
+	for i, entry := range m.entries {
+		if entry.Manager == manager {
+			continue
+		}
+		if conflicting := m.sets[i].Intersection(applied).Intersection(changed); !conflicting.Empty() {
+			owners[entry.Manager] = fieldpath.NewVersionedSet(conflicting, fieldpath.APIVersion(entry.APIVersion), entry.Operation == metav1.ManagedFieldsOperationApply)
+		}
+	}
+	return merge.ConflictsFromManagers(owners)
+}
+
+// Apply records that the manager has applied the fields, with the semantics
+// of a forced server-side apply: the applied fields replace the previously
+// applied ones of the manager, and other managers lose their ownership of
+// the conflicting fields, but share the ownership of the others. Managers
+// that own no more fields are dropped.
+func (m *ManagedFields) Apply(manager, apiVersion string, applied *fieldpath.Set, conflicts merge.Conflicts) {
+	taken := conflicts.ToSet()
+	var entries []metav1.ManagedFieldsEntry
+	var sets []*fieldpath.Set
+	for i, entry := range m.entries {
+		if entry.Manager == manager && entry.Operation == metav1.ManagedFieldsOperationApply {
+			continue
+		}
+		set := m.sets[i].Difference(taken)
+		if set.Empty() {
+			continue
+		}
+		entries = append(entries, entry)
+		sets = append(sets, set)
+	}
+	m.entries = append(entries, metav1.ManagedFieldsEntry{
+		Manager:    manager,
+		Operation:  metav1.ManagedFieldsOperationApply,
+		APIVersion: apiVersion,
+		FieldsType: "FieldsV1",
+	})
+	m.sets = append(sets, applied)
+}
+
+// Unstructured encodes the managed fields as the value of
+// metadata.managedFields.
+func (m *ManagedFields) Unstructured() ([]any, error) {
+	raw := make([]any, 0, len(m.entries))
+	for i, entry := range m.entries {
+		fields, err := m.sets[i].ToJSON()
+		if err != nil {
+			return nil, err
+		}
+		entry.FieldsV1 = &metav1.FieldsV1{Raw: fields}
+		u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&entry)
+		if err != nil {
+			return nil, err
+		}
+		raw = append(raw, u)
+	}
+	return raw, nil
+}
+
+// ChangedFields returns the fields that differ between the objects.
+func ChangedFields(t *typed.ParseableType, original, object map[string]any) (*fieldpath.Set, error) {
+	lhs, err := t.FromUnstructured(original)
+	if err != nil {
+		return nil, err
+	}
+	rhs, err := t.FromUnstructured(object)
+	if err != nil {
+		return nil, err
+	}
+	c, err := lhs.Compare(rhs)
+	if err != nil {
+		return nil, err
+	}
+	return c.Modified.Union(c.Removed).Union(c.Added), nil
+}
@@ -0,0 +1,81 @@
+package apply
+
+import (
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+	"sigs.k8s.io/structured-merge-diff/v4/merge"
+)
+
+func TestManagedFields(t *testing.T) {
+	object := map[string]any{
+		"metadata": map[string]any{
+			"managedFields": []any{
+				map[string]any{
+					"manager":    "kubectl",
+					"operation":  "Apply",
+					"apiVersion": "apps/v1",
+					"fieldsType": "FieldsV1",
+					"fieldsV1": map[string]any{
+						"f:spec": map[string]any{
+							"f:replicas": map[string]any{},
+							"f:paused":   map[string]any{},
+						},
+					},
+				},
+				map[string]any{
+					"manager":    "policy",
+					"operation":  "Apply",
+					"apiVersion": "apps/v1",
+					"fieldsType": "FieldsV1",
+					"fieldsV1": map[string]any{
+						"f:spec": map[string]any{"f:minReadySeconds": map[string]any{}},
+					},
+				},
+			},
+		},
+	}
+	managed, err := ManagedFieldsOf(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicas := fieldpath.MakePathOrDie("spec", "replicas")
+	paused := fieldpath.MakePathOrDie("spec", "paused")
+	applied := fieldpath.NewSet(replicas, paused)
+	changed := fieldpath.NewSet(replicas)
+
+	conflicts := managed.Conflicts("policy", applied, changed)
+	expected := merge.Conflicts{{Manager: "kubectl", Path: replicas}}
+	if !conflicts.Equals(expected) {
+		t.Fatalf("expected conflicts %v but got %v", expected, conflicts)
+	}
+
+	managed.Apply("policy", "apps/v1", applied, conflicts)
+	entries, err := managed.Unstructured()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedEntries := []any{
+		map[string]any{
+			"manager":    "kubectl",
+			"operation":  "Apply",
+			"apiVersion": "apps/v1",
+			"fieldsType": "FieldsV1",
+			"fieldsV1":   map[string]any{"f:spec": map[string]any{"f:paused": map[string]any{}}},
+		},
+		map[string]any{
+			"manager":    "policy",
+			"operation":  "Apply",
+			"apiVersion": "apps/v1",
+			"fieldsType": "FieldsV1",
+			"fieldsV1": map[string]any{"f:spec": map[string]any{
+				"f:paused":   map[string]any{},
+				"f:replicas": map[string]any{},
+			}},
+		},
+	}
+	if !reflect.DeepEqual(entries, expectedEntries) {
+		t.Errorf("expected %v but got %v", expectedEntries, entries)
+	}
+}
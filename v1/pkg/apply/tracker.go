@@ -1,28 +1,478 @@
 package apply
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 
 	"k8s.io/kube-openapi/pkg/validation/spec"
+	"sigs.k8s.io/structured-merge-diff/v4/typed"
 )
 
+var ErrFieldNotFound = errors.New("field not found")
+var ErrNotArray = errors.New("not an array")
+var ErrUnresolvedRef = errors.New("unresolved reference")
+
+// componentsPrefix and definitionsPrefix are the prefixes of the references
+// to named schemas in OpenAPI v3 and v2 documents respectively.
+const componentsPrefix = "#/components/schemas/"
+const definitionsPrefix = "#/definitions/"
+
+// maxRefDepth bounds the chain of references that resolve follows, and the
+// nesting of allOf that flatten merges, so that a cycle is reported instead
+// of looping forever.
+const maxRefDepth = 64
+
+const extPreserveUnknownFields = "x-kubernetes-preserve-unknown-fields"
+
+// ComponentRef returns a schema that refers to the named component of an
+// OpenAPI v3 document.
+func ComponentRef(name string) *spec.Schema {
+	return spec.RefSchema(componentsPrefix + pointerEscaper.Replace(name))
+}
+
+// SchemaTracker tracks a position in a schema while walking down a value.
+// References to the components of the OpenAPI document are resolved, and
+// the subschemas of allOf are searched, as the tracker advances.
 type SchemaTracker struct {
-	root *spec.Schema
-	path []string
+	components map[string]*spec.Schema
+	schema     *spec.Schema
+	path       []string
+
+	// root is shared by all trackers advanced from the same root.
+	root *rootType
 }
 
+// rootType holds the structured-merge-diff type of the root schema, which
+// is created at most once.
+type rootType struct {
+	schema *spec.Schema
+	once   sync.Once
+	t      *typed.ParseableType
+	err    error
+}
+
+// NewSchemaTracker creates a tracker at the root schema. The components
+// are the named schemas that references can refer to, and can be nil.
+func NewSchemaTracker(root *spec.Schema, components map[string]*spec.Schema) *SchemaTracker {
+	return &SchemaTracker{components: components, schema: root, root: &rootType{schema: root}}
+}
+
+// Schema returns the schema at the current position, with its reference
+// resolved and the subschemas of allOf merged into it. The schemas of its
+// children may still have references; advance to them to resolve those.
 func (t *SchemaTracker) Schema() *spec.Schema {
-	var schema spec.Schema
-	for _, part := range t.path {
-		schema = t.root.Properties[part]
+	s, err := t.flatten(t.schema, 0)
+	if err != nil {
+		return t.schema
 	}
-	return &schema
+	return s
 }
 
-func (t *SchemaTracker) Advance(fieldName string) (*SchemaTracker, error) {
+// Resolve returns a copy of the schema at the current position with all
+// references resolved and the subschemas of allOf merged into their parents,
+// so that it can be used wherever a self-contained schema is expected, such
+// as by CreateObjectType. A reference back to a schema that is being resolved
+// is replaced by a schema that preserves unknown fields.
+func (t *SchemaTracker) Resolve() (*spec.Schema, error) {
+	return t.resolveAll(t.schema, make(map[string]bool))
+}
+
+// Type returns the structured-merge-diff type at the current position. The
+// type of the root schema is created once, with all references resolved,
+// and shared by the trackers advanced from the root, each of which walks it
+// along its path.
+func (t *SchemaTracker) Type() (*typed.ParseableType, error) {
+	r := t.root
+	r.once.Do(func() {
+		var s *spec.Schema
+		s, r.err = (&SchemaTracker{components: t.components, schema: r.schema}).Resolve()
+		if r.err == nil {
+			r.t, r.err = CreateObjectType(s)
+		}
+	})
+	if r.err != nil {
+		return nil, r.err
+	}
+	ref := r.t.TypeRef
+	for i, part := range t.path {
+		atom, ok := r.t.Schema.Resolve(ref)
+		if !ok {
+			return nil, fmt.Errorf("%s: cannot resolve type %v", pathOf(t.path[:i]), ref)
+		}
+		if atom.List != nil && (atom.Map == nil || isIndex(part)) {
+			ref = atom.List.ElementType
+			continue
+		}
+		if atom.Map == nil {
+			return nil, fmt.Errorf("%s: %w: %q", pathOf(t.path[:i]), ErrFieldNotFound, part)
+		}
+		if f, ok := atom.Map.FindField(part); ok {
+			ref = f.Type
+		} else {
+			ref = atom.Map.ElementType
+		}
+	}
+	return &typed.ParseableType{Schema: r.t.Schema, TypeRef: ref}, nil
+}
+
+// Alternatives returns the trackers of the subschemas of oneOf and anyOf at
+// the current position.
+func (t *SchemaTracker) Alternatives() []*SchemaTracker {
 	s := t.Schema()
-	if _, ok := s.Properties[fieldName]; ok {
-		return &SchemaTracker{root: t.root, path: append(t.path, fieldName)}, nil
+	alternatives := make([]*SchemaTracker, 0, len(s.OneOf)+len(s.AnyOf))
+	for _, subschemas := range [][]spec.Schema{s.OneOf, s.AnyOf} {
+		for i := range subschemas {
+			alternatives = append(alternatives, &SchemaTracker{components: t.components, schema: &subschemas[i], path: t.path, root: t.root})
+		}
+	}
+	return alternatives
+}
+
+// Path returns the JSON pointer of the current position from the root.
+func (t *SchemaTracker) Path() string {
+	return pathOf(t.path)
+}
+
+// pathOf returns the JSON pointer of the path.
+func pathOf(path []string) string {
+	var sb strings.Builder
+	for _, part := range path {
+		sb.WriteString("/")
+		sb.WriteString(pointerEscaper.Replace(part))
+	}
+	return sb.String()
+}
+
+// isIndex returns whether the part of a path is an index of an array.
+func isIndex(part string) bool {
+	_, err := strconv.Atoi(part)
+	return err == nil
+}
+
+// Advance moves to the named field of an object, which is either a property
+// or, for a map, a key of which the schema is additionalProperties.
+func (t *SchemaTracker) Advance(fieldName string) (*SchemaTracker, error) {
+	s, err := t.resolve(t.schema)
+	if err != nil {
+		return nil, err
+	}
+	child, err := t.property(s, fieldName)
+	if err != nil {
+		return nil, err
+	}
+	if child == nil {
+		return nil, fmt.Errorf("%s: %w: %q", t.Path(), ErrFieldNotFound, fieldName)
+	}
+	return t.advance(child, fieldName), nil
+}
+
+// AdvanceIndex moves to the element at the index of an array.
+func (t *SchemaTracker) AdvanceIndex(index int) (*SchemaTracker, error) {
+	s, err := t.resolve(t.schema)
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("%s: invalid index %d", t.Path(), index)
+	}
+	items, err := t.items(s)
+	if err != nil {
+		return nil, err
+	}
+	if items == nil {
+		return nil, fmt.Errorf("%s: %w", t.Path(), ErrNotArray)
+	}
+	return t.advance(items, strconv.Itoa(index)), nil
+}
+
+// AdvancePointer moves along the JSON pointer (RFC 6901). A reference token
+// is an index if the schema at the position is an array, and a field name
+// otherwise.
+func (t *SchemaTracker) AdvancePointer(pointer string) (*SchemaTracker, error) {
+	if pointer == "" {
+		return t, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+	current := t
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = pointerUnescaper.Replace(token)
+		s, err := current.resolve(current.schema)
+		if err != nil {
+			return nil, err
+		}
+		if isArray(s) {
+			index, err := strconv.Atoi(token)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid index %q", current.Path(), token)
+			}
+			current, err = current.AdvanceIndex(index)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		current, err = current.Advance(token)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return current, nil
+}
+
+func (t *SchemaTracker) advance(s *spec.Schema, part string) *SchemaTracker {
+	path := make([]string, len(t.path), len(t.path)+1)
+	copy(path, t.path)
+	return &SchemaTracker{components: t.components, schema: s, path: append(path, part), root: t.root}
+}
+
+// resolve follows the reference of the schema, if any.
+func (t *SchemaTracker) resolve(s *spec.Schema) (*spec.Schema, error) {
+	for depth := 0; s != nil; depth++ {
+		ref := s.Ref.String()
+		if ref == "" {
+			return s, nil
+		}
+		if depth >= maxRefDepth {
+			return nil, fmt.Errorf("%s: %w: %s: reference cycle", t.Path(), ErrUnresolvedRef, ref)
+		}
+		var err error
+		if _, s, err = t.component(ref); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// component returns the name and the schema of the component that the
+// reference refers to.
+func (t *SchemaTracker) component(ref string) (string, *spec.Schema, error) {
+	var name string
+	switch {
+	case strings.HasPrefix(ref, componentsPrefix):
+		name = ref[len(componentsPrefix):]
+	case strings.HasPrefix(ref, definitionsPrefix):
+		name = ref[len(definitionsPrefix):]
+	default:
+		return "", nil, fmt.Errorf("%s: %w: %s", t.Path(), ErrUnresolvedRef, ref)
+	}
+	name = pointerUnescaper.Replace(name)
+	target, ok := t.components[name]
+	if !ok {
+		return "", nil, fmt.Errorf("%s: %w: %s", t.Path(), ErrUnresolvedRef, ref)
+	}
+	return name, target, nil
+}
+
+// flatten resolves the schema and merges the subschemas of its allOf into it.
+func (t *SchemaTracker) flatten(s *spec.Schema, depth int) (*spec.Schema, error) {
+	s, err := t.resolve(s)
+	if err != nil || s == nil || len(s.AllOf) == 0 {
+		return s, err
+	}
+	if depth >= maxRefDepth {
+		return nil, fmt.Errorf("%s: %w: allOf cycle", t.Path(), ErrUnresolvedRef)
+	}
+	flattened := *s
+	flattened.AllOf = nil
+	for i := range s.AllOf {
+		sub, err := t.flatten(&s.AllOf[i], depth+1)
+		if err != nil {
+			return nil, err
+		}
+		mergeSchema(&flattened, sub)
 	}
-	return nil, fmt.Errorf("field not found: %q", fieldName)
+	return &flattened, nil
 }
+
+// resolveAll copies the schema, resolving the references within it. The
+// resolving set holds the names of the components being resolved.
+func (t *SchemaTracker) resolveAll(s *spec.Schema, resolving map[string]bool) (*spec.Schema, error) {
+	if s == nil {
+		return nil, nil
+	}
+	if ref := s.Ref.String(); ref != "" {
+		name, target, err := t.component(ref)
+		if err != nil {
+			return nil, err
+		}
+		if resolving[name] {
+			return unknownFields(), nil
+		}
+		resolving[name] = true
+		defer delete(resolving, name)
+		return t.resolveAll(target, resolving)
+	}
+	resolved := *s
+	var err error
+	if s.Properties != nil {
+		resolved.Properties = make(map[string]spec.Schema, len(s.Properties))
+		for name := range s.Properties {
+			p := s.Properties[name]
+			r, err := t.resolveAll(&p, resolving)
+			if err != nil {
+				return nil, err
+			}
+			resolved.Properties[name] = *r
+		}
+	}
+	if s.Items != nil {
+		items := *s.Items
+		if items.Schema, err = t.resolveAll(s.Items.Schema, resolving); err != nil {
+			return nil, err
+		}
+		if items.Schemas, err = t.resolveEach(s.Items.Schemas, resolving); err != nil {
+			return nil, err
+		}
+		resolved.Items = &items
+	}
+	if s.AdditionalProperties != nil {
+		additional := *s.AdditionalProperties
+		if additional.Schema, err = t.resolveAll(s.AdditionalProperties.Schema, resolving); err != nil {
+			return nil, err
+		}
+		resolved.AdditionalProperties = &additional
+	}
+	if resolved.OneOf, err = t.resolveEach(s.OneOf, resolving); err != nil {
+		return nil, err
+	}
+	if resolved.AnyOf, err = t.resolveEach(s.AnyOf, resolving); err != nil {
+		return nil, err
+	}
+	if resolved.Not, err = t.resolveAll(s.Not, resolving); err != nil {
+		return nil, err
+	}
+	allOf, err := t.resolveEach(s.AllOf, resolving)
+	if err != nil {
+		return nil, err
+	}
+	resolved.AllOf = nil
+	for i := range allOf {
+		mergeSchema(&resolved, &allOf[i])
+	}
+	return &resolved, nil
+}
+
+func (t *SchemaTracker) resolveEach(schemas []spec.Schema, resolving map[string]bool) ([]spec.Schema, error) {
+	if schemas == nil {
+		return nil, nil
+	}
+	resolved := make([]spec.Schema, len(schemas))
+	for i := range schemas {
+		r, err := t.resolveAll(&schemas[i], resolving)
+		if err != nil {
+			return nil, err
+		}
+		resolved[i] = *r
+	}
+	return resolved, nil
+}
+
+// property returns the schema of the named field of the resolved schema,
+// searching the subschemas of allOf, or nil if there is none.
+func (t *SchemaTracker) property(s *spec.Schema, name string) (*spec.Schema, error) {
+	if p, ok := s.Properties[name]; ok {
+		return &p, nil
+	}
+	for i := range s.AllOf {
+		sub, err := t.resolve(&s.AllOf[i])
+		if err != nil {
+			return nil, err
+		}
+		p, err := t.property(sub, name)
+		if err != nil || p != nil {
+			return p, err
+		}
+	}
+	if s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+		return s.AdditionalProperties.Schema, nil
+	}
+	return nil, nil
+}
+
+// items returns the schema of the elements of the resolved schema, searching
+// the subschemas of allOf, or nil if there is none.
+func (t *SchemaTracker) items(s *spec.Schema) (*spec.Schema, error) {
+	if s.Items != nil && s.Items.Schema != nil {
+		return s.Items.Schema, nil
+	}
+	for i := range s.AllOf {
+		sub, err := t.resolve(&s.AllOf[i])
+		if err != nil {
+			return nil, err
+		}
+		items, err := t.items(sub)
+		if err != nil || items != nil {
+			return items, err
+		}
+	}
+	return nil, nil
+}
+
+// mergeSchema merges a subschema of allOf into its parent. What the parent
+// specifies itself takes precedence.
+func mergeSchema(parent, sub *spec.Schema) {
+	if len(parent.Type) == 0 {
+		parent.Type = sub.Type
+	}
+	if parent.Format == "" {
+		parent.Format = sub.Format
+	}
+	if parent.Description == "" {
+		parent.Description = sub.Description
+	}
+	parent.Nullable = parent.Nullable || sub.Nullable
+	if len(sub.Properties) > 0 {
+		properties := make(map[string]spec.Schema, len(parent.Properties)+len(sub.Properties))
+		for name, p := range sub.Properties {
+			properties[name] = p
+		}
+		for name, p := range parent.Properties {
+			properties[name] = p
+		}
+		parent.Properties = properties
+	}
+	if len(sub.Required) > 0 {
+		parent.Required = append(append([]string{}, parent.Required...), sub.Required...)
+	}
+	if parent.Items == nil {
+		parent.Items = sub.Items
+	}
+	if parent.AdditionalProperties == nil {
+		parent.AdditionalProperties = sub.AdditionalProperties
+	}
+	if parent.OneOf == nil {
+		parent.OneOf = sub.OneOf
+	}
+	if parent.AnyOf == nil {
+		parent.AnyOf = sub.AnyOf
+	}
+	if len(sub.Extensions) > 0 {
+		extensions := make(spec.Extensions, len(parent.Extensions)+len(sub.Extensions))
+		for k, v := range sub.Extensions {
+			extensions[k] = v
+		}
+		for k, v := range parent.Extensions {
+			extensions[k] = v
+		}
+		parent.Extensions = extensions
+	}
+}
+
+// unknownFields returns a schema that allows any value.
+func unknownFields() *spec.Schema {
+	return &spec.Schema{VendorExtensible: spec.VendorExtensible{
+		Extensions: spec.Extensions{extPreserveUnknownFields: true},
+	}}
+}
+
+func isArray(s *spec.Schema) bool {
+	return len(s.Type) > 0 && s.Type[0] == "array" || s.Items != nil
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
@@ -0,0 +1,214 @@
+package apply
+
+import (
+	"errors"
+	"testing"
+
+	"k8s.io/kube-openapi/pkg/validation/spec"
+)
+
+func trackerComponents() map[string]*spec.Schema {
+	ref := func(name string) spec.Schema {
+		return *spec.RefSchema(componentsPrefix + name)
+	}
+	return map[string]*spec.Schema{
+		"Deployment": {SchemaProps: spec.SchemaProps{
+			Type: []string{"object"},
+			Properties: map[string]spec.Schema{
+				"metadata": {SchemaProps: spec.SchemaProps{AllOf: []spec.Schema{ref("ObjectMeta")}}},
+				"spec":     ref("DeploymentSpec"),
+			},
+		}},
+		"ObjectMeta": {SchemaProps: spec.SchemaProps{
+			Type: []string{"object"},
+			Properties: map[string]spec.Schema{
+				"labels": *spec.MapProperty(spec.StringProperty()),
+			},
+		}},
+		"DeploymentSpec": {SchemaProps: spec.SchemaProps{
+			Type: []string{"object"},
+			Properties: map[string]spec.Schema{
+				"replicas":   *spec.Int32Property(),
+				"containers": *spec.ArrayProperty(spec.RefSchema(componentsPrefix + "Container")),
+			},
+		}},
+		"Container": {SchemaProps: spec.SchemaProps{
+			Type: []string{"object"},
+			Properties: map[string]spec.Schema{
+				"name": *spec.StringProperty(),
+			},
+		}},
+		"Loop": spec.RefSchema(componentsPrefix + "Loop"),
+	}
+}
+
+func TestSchemaTracker(t *testing.T) {
+	components := trackerComponents()
+	root := NewSchemaTracker(spec.RefSchema(componentsPrefix+"Deployment"), components)
+	for _, tc := range []struct {
+		pointer      string
+		expectedType string
+		expectedErr  error
+	}{
+		{pointer: "", expectedType: "object"},
+		{pointer: "/spec/replicas", expectedType: "integer"},
+		{pointer: "/metadata/labels", expectedType: "object"},
+		{pointer: "/metadata/labels/app.kubernetes.io~1name", expectedType: "string"},
+		{pointer: "/spec/containers", expectedType: "array"},
+		{pointer: "/spec/containers/0/name", expectedType: "string"},
+		{pointer: "/spec/paused", expectedErr: ErrFieldNotFound},
+		{pointer: "/spec/replicas/0", expectedErr: ErrFieldNotFound},
+		{pointer: "/metadata/labels/app/0", expectedErr: ErrFieldNotFound},
+	} {
+		t.Run(tc.pointer, func(t *testing.T) {
+			tracker, err := root.AdvancePointer(tc.pointer)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v but got %v", tc.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if tracker.Path() != tc.pointer {
+				t.Errorf("expected path %q but got %q", tc.pointer, tracker.Path())
+			}
+			if s := tracker.Schema(); len(s.Type) == 0 || s.Type[0] != tc.expectedType {
+				t.Errorf("expected type %q but got %v", tc.expectedType, s.Type)
+			}
+		})
+	}
+}
+
+func TestSchemaTrackerErrors(t *testing.T) {
+	components := trackerComponents()
+	deploymentSpec := NewSchemaTracker(spec.RefSchema(componentsPrefix+"DeploymentSpec"), components)
+	if _, err := deploymentSpec.AdvanceIndex(0); !errors.Is(err, ErrNotArray) {
+		t.Errorf("expected ErrNotArray but got %v", err)
+	}
+	containers, err := deploymentSpec.Advance("containers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := containers.AdvanceIndex(-1); err == nil {
+		t.Errorf("expected an error on a negative index")
+	}
+	if _, err := containers.AdvancePointer("/first"); err == nil {
+		t.Errorf("expected an error on a non-numeric index")
+	}
+	loop := NewSchemaTracker(components["Loop"], components)
+	if _, err := loop.Advance("any"); !errors.Is(err, ErrUnresolvedRef) {
+		t.Errorf("expected ErrUnresolvedRef but got %v", err)
+	}
+	missing := NewSchemaTracker(spec.RefSchema(componentsPrefix+"Missing"), nil)
+	if _, err := missing.Advance("any"); !errors.Is(err, ErrUnresolvedRef) {
+		t.Errorf("expected ErrUnresolvedRef but got %v", err)
+	}
+}
+
+func TestSchemaTrackerResolve(t *testing.T) {
+	components := trackerComponents()
+	components["Node"] = &spec.Schema{SchemaProps: spec.SchemaProps{
+		Type: []string{"object"},
+		Properties: map[string]spec.Schema{
+			"children": *spec.ArrayProperty(spec.RefSchema(componentsPrefix + "Node")),
+			"value": {SchemaProps: spec.SchemaProps{
+				OneOf: []spec.Schema{*spec.StringProperty(), *spec.RefSchema(componentsPrefix + "Container")},
+			}},
+		},
+	}}
+	root := NewSchemaTracker(spec.RefSchema(componentsPrefix+"Deployment"), components)
+	metadata, err := root.Advance("metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := metadata.Schema(); len(s.Type) == 0 || s.Type[0] != "object" || len(s.AllOf) != 0 {
+		t.Errorf("expected allOf to be merged, but got %v", s)
+	}
+	if _, ok := metadata.Schema().Properties["labels"]; !ok {
+		t.Errorf("expected the properties of allOf to be merged")
+	}
+
+	s, err := root.Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	containers := s.Properties["spec"].Properties["containers"]
+	if name := containers.Items.Schema.Properties["name"]; len(name.Type) == 0 || name.Type[0] != "string" {
+		t.Errorf("expected the references to be resolved, but got %v", containers.Items.Schema)
+	}
+	if _, ok := s.Properties["metadata"].Properties["labels"]; !ok {
+		t.Errorf("expected allOf to be merged, but got %v", s.Properties["metadata"])
+	}
+
+	node, err := NewSchemaTracker(spec.RefSchema(componentsPrefix+"Node"), components).Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := node.Properties["children"].Items.Schema
+	if preserve, _ := child.Extensions.GetBool(extPreserveUnknownFields); !preserve {
+		t.Errorf("expected the cyclic reference to preserve unknown fields, but got %v", child)
+	}
+
+	value, err := NewSchemaTracker(components["Node"], components).Advance("value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alternatives := value.Alternatives()
+	if len(alternatives) != 2 {
+		t.Fatalf("expected 2 alternatives but got %d", len(alternatives))
+	}
+	if _, err := alternatives[1].Advance("name"); err != nil {
+		t.Errorf("expected the reference of an alternative to be resolved, but got %v", err)
+	}
+	if alternatives[1].Path() != "/value" {
+		t.Errorf("unexpected path of an alternative: %q", alternatives[1].Path())
+	}
+}
+
+func TestSchemaTrackerType(t *testing.T) {
+	root := NewSchemaTracker(spec.RefSchema(componentsPrefix+"Deployment"), trackerComponents())
+	rootType, err := root.Type()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		pointer       string
+		expectedAtom  string
+		expectedField string
+	}{
+		{pointer: "", expectedAtom: "map", expectedField: "spec"},
+		{pointer: "/spec/replicas", expectedAtom: "scalar"},
+		{pointer: "/metadata/labels/app", expectedAtom: "scalar"},
+		{pointer: "/spec/containers", expectedAtom: "list"},
+		{pointer: "/spec/containers/0", expectedAtom: "map", expectedField: "name"},
+	} {
+		t.Run(tc.pointer, func(t *testing.T) {
+			tracker, err := root.AdvancePointer(tc.pointer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			typ, err := tracker.Type()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the type of the root is created once and shared.
+			if typ.Schema != rootType.Schema {
+				t.Errorf("expected the schema of the root type to be shared")
+			}
+			atom, ok := typ.Schema.Resolve(typ.TypeRef)
+			if !ok {
+				t.Fatalf("cannot resolve %v", typ.TypeRef)
+			}
+			switch {
+			case tc.expectedAtom == "scalar" && atom.Scalar == nil,
+				tc.expectedAtom == "list" && atom.List == nil,
+				tc.expectedAtom == "map" && atom.Map == nil:
+				t.Fatalf("expected a %s but got %v", tc.expectedAtom, atom)
+			}
+			if tc.expectedField != "" {
+				if _, ok := atom.Map.FindField(tc.expectedField); !ok {
+					t.Errorf("expected field %q in %v", tc.expectedField, atom.Map)
+				}
+			}
+		})
+	}
+}
@@ -18,3 +18,14 @@ func CreateObjectType(objectSchema *spec.Schema) (*typed.ParseableType, error) {
 	t := parser.Type("object")
 	return &t, nil
 }
+
+// TypeOf returns the type of the values that the schema describes, or the
+// type deduced from the values themselves if the schema is nil, in which
+// case objects are merged and lists are atomic.
+func TypeOf(s *spec.Schema) (*typed.ParseableType, error) {
+	if s == nil {
+		t := typed.DeducedParseableType
+		return &t, nil
+	}
+	return CreateObjectType(s)
+}
@@ -1,9 +1,28 @@
+// Package cel declares the mutator functions to CEL.
+//
+// Objects and lists are held by mutators, but there are no mutators of
+// scalars: a scalar is read as a plain value. Assigning or removing a
+// scalar is therefore done by its parent, through parent.set(name, value),
+// parent.set(index, value), parent.remove(name) and parent.remove(index).
+// The set and remove macros rewrite the familiar forms
+//
+//	object.spec.replicas.set(3)
+//	object.metadata.labels["team"].remove()
+//
+// to those calls, so that they work for fields and elements of any type.
 package cel
 
 import (
+	"fmt"
+
 	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common"
+	"github.com/google/cel-go/common/operators"
 	"github.com/google/cel-go/common/types"
 	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/google/cel-go/parser"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
 
 	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/mutator"
 )
@@ -11,14 +30,50 @@ import (
 const overloadNameObjectMerge = "mutator_object_merge"
 const overloadNameObjectRemove = "mutator_object_remove"
 const overloadNameListMerge = "mutator_list_merge"
+const overloadNameObjectApply = "mutator_object_apply"
+const overloadNameListApply = "mutator_list_apply"
 const overloadNameListRemove = "mutator_list_remove"
+const overloadNameObjectEnsure = "mutator_object_ensure"
+const overloadNameSet = "mutator_set"
+const overloadNameObjectSetField = "mutator_object_set_field"
+const overloadNameListSetElement = "mutator_list_set_element"
+const overloadNameObjectRemoveField = "mutator_object_remove_field"
+const overloadNameListRemoveElement = "mutator_list_remove_element"
+const overloadNameListElements = "mutator_list_elements"
+const overloadNameListRemoveWhere = "mutator_list_remove_where"
+
+// functionElements and functionRemoveWhere are internal functions that the
+// removeWhere macro expands to. The names cannot be parsed, so that they
+// cannot be called directly.
+const functionElements = "@elements"
+const functionRemoveWhere = "@removeWhere"
+
+// functionField is an internal function that the set and remove macros
+// expand to. It returns the field name, its second argument, as is. Its
+// first argument is the presence test of the field, which is only there so
+// that the checker reports a field that the type of the object does not
+// declare.
+const functionField = "@field"
+const overloadNameField = "mutator_field"
+
+// maxEnsurePathLength is the maximum number of field names that ensure
+// accepts in a single call.
+const maxEnsurePathLength = 8
 
 func MergeOperation(lhs, rhs ref.Val) ref.Val {
 	mutator, ok := lhs.(mutator.Interface)
 	if !ok {
 		return types.NoSuchOverloadErr()
 	}
-	return mutator.Merge(rhs.Value())
+	return mutator.Merge(rhs)
+}
+
+func ApplyOperation(lhs, rhs ref.Val) ref.Val {
+	mutator, ok := lhs.(mutator.Interface)
+	if !ok {
+		return types.NoSuchOverloadErr()
+	}
+	return mutator.Apply(rhs)
 }
 
 func RemoveOperation(lhs ref.Val) ref.Val {
@@ -29,23 +84,295 @@ func RemoveOperation(lhs ref.Val) ref.Val {
 	return mutator.Remove()
 }
 
+func SetOperation(lhs, rhs ref.Val) ref.Val {
+	mutator, ok := lhs.(mutator.Interface)
+	if !ok {
+		return types.NoSuchOverloadErr()
+	}
+	return mutator.Set(rhs)
+}
+
+func SetFieldOperation(args ...ref.Val) ref.Val {
+	s, ok := args[0].(mutator.FieldSetter)
+	if !ok {
+		return types.NoSuchOverloadErr()
+	}
+	name, ok := args[1].(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(args[1])
+	}
+	return s.SetField(string(name), args[2])
+}
+
+// elementSetter is implemented by list mutators.
+type elementSetter interface {
+	SetElement(index int, value ref.Val) ref.Val
+}
+
+func SetElementOperation(args ...ref.Val) ref.Val {
+	s, ok := args[0].(elementSetter)
+	if !ok {
+		return types.NoSuchOverloadErr()
+	}
+	index, ok := args[1].(types.Int)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(args[1])
+	}
+	return s.SetElement(int(index), args[2])
+}
+
+// removeOperation removes the mutator, or its child if a key is given. All
+// overloads of remove share it, because the overloads on typed object
+// mutators are declared for type checking only.
+func removeOperation(args ...ref.Val) ref.Val {
+	if len(args) == 1 {
+		return RemoveOperation(args[0])
+	}
+	return RemoveChildOperation(args[0], args[1])
+}
+
+// RemoveChildOperation removes the field of an object, or the element of a
+// list, identified by rhs. Like reading it, removing a missing child is an
+// error.
+func RemoveChildOperation(lhs, rhs ref.Val) ref.Val {
+	container, ok := lhs.(mutator.Container)
+	if !ok {
+		return types.NoSuchOverloadErr()
+	}
+	var identifier any
+	switch key := rhs.(type) {
+	case types.String:
+		identifier = string(key)
+	case types.Int:
+		identifier = int(key)
+	default:
+		return types.MaybeNoSuchOverloadErr(rhs)
+	}
+	if _, ok := container.Child(identifier); !ok {
+		return types.NewErr("no such key: %v", rhs)
+	}
+	if err := container.RemoveChild(identifier); err != nil {
+		return types.WrapErr(err)
+	}
+	return types.NullValue
+}
+
+// setMacro expands
+//
+//	<container>.<field>.set(<value>)
+//	<container>[<key>].set(<value>)
+//
+// to <container>.set(<key>, <value>), because scalars are read as plain
+// values that cannot be set by themselves. Other targets are left as they are.
+// See splitTarget for how the names of fields are checked.
+func setMacro(eh cel.MacroExprHelper, target *exprpb.Expr, args []*exprpb.Expr) (*exprpb.Expr, *common.Error) {
+	container, key := splitTarget(eh, target)
+	if container == nil {
+		return nil, nil
+	}
+	return eh.ReceiverCall("set", container, key, args[0]), nil
+}
+
+// removeMacro expands
+//
+//	<container>.<field>.remove()
+//	<container>[<key>].remove()
+//
+// to <container>.remove(<key>), for the same reason as setMacro.
+func removeMacro(eh cel.MacroExprHelper, target *exprpb.Expr, args []*exprpb.Expr) (*exprpb.Expr, *common.Error) {
+	container, key := splitTarget(eh, target)
+	if container == nil {
+		return nil, nil
+	}
+	return eh.ReceiverCall("remove", container, key), nil
+}
+
+// splitTarget splits a field selection or an index expression into the
+// container and the key. Both are nil for any other expression. The key of
+// a field selection is @field(has(<container>.<field>), "<field>"), so that
+// a misspelled field of a typed object fails to compile as it would if read.
+func splitTarget(eh cel.MacroExprHelper, target *exprpb.Expr) (*exprpb.Expr, *exprpb.Expr) {
+	if sel := target.GetSelectExpr(); sel != nil && !sel.GetTestOnly() {
+		present := eh.PresenceTest(eh.Copy(sel.GetOperand()), sel.GetField())
+		return sel.GetOperand(), eh.GlobalCall(functionField, present, eh.LiteralString(sel.GetField()))
+	}
+	if call := target.GetCallExpr(); call != nil && call.GetTarget() == nil &&
+		call.GetFunction() == operators.Index && len(call.GetArgs()) == 2 {
+		return call.GetArgs()[0], call.GetArgs()[1]
+	}
+	return nil, nil
+}
+
+// elementRemover is implemented by list mutators.
+type elementRemover interface {
+	Elements() ref.Val
+	RemoveElements(indices []int) ref.Val
+}
+
+func ElementsOperation(lhs ref.Val) ref.Val {
+	r, ok := lhs.(elementRemover)
+	if !ok {
+		return types.NoSuchOverloadErr()
+	}
+	return r.Elements()
+}
+
+// RemoveWhereOperation removes the elements of the list for which the
+// corresponding entries of the given list of booleans are true.
+func RemoveWhereOperation(lhs, rhs ref.Val) ref.Val {
+	r, ok := lhs.(elementRemover)
+	if !ok {
+		return types.NoSuchOverloadErr()
+	}
+	matches, ok := rhs.(traits.Lister)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(rhs)
+	}
+	var indices []int
+	for i := types.Int(0); i < matches.Size().(types.Int); i++ {
+		match, ok := matches.Get(i).(types.Bool)
+		if !ok {
+			return types.NewErr("removeWhere: predicate must evaluate to bool")
+		}
+		if match {
+			indices = append(indices, int(i))
+		}
+	}
+	return r.RemoveElements(indices)
+}
+
+// removeWhereMacro expands
+//
+//	<list>.removeWhere(<x>, <predicate>)
+//
+// to a call that removes the elements of the list for which the predicate
+// is true, evaluated on read-only copies of the elements before any removal.
+func removeWhereMacro(eh cel.MacroExprHelper, target *exprpb.Expr, args []*exprpb.Expr) (*exprpb.Expr, *common.Error) {
+	v := args[0].GetIdentExpr().GetName()
+	if v == "" {
+		return nil, eh.NewError(args[0].GetId(), "argument is not an identifier")
+	}
+	accu := eh.AccuIdent()
+	matches := eh.Fold(v, eh.GlobalCall(functionElements, eh.Copy(target)),
+		parser.AccumulatorName, eh.NewList(), eh.LiteralBool(true),
+		eh.GlobalCall(operators.Add, accu, eh.NewList(args[1])), eh.AccuIdent())
+	return eh.ReceiverCall(functionRemoveWhere, target, matches), nil
+}
+
+// FieldOperation returns the field name, ignoring whether the field is present.
+func FieldOperation(_, name ref.Val) ref.Val {
+	return name
+}
+
+// ensurer is implemented by mutators that can create missing fields.
+type ensurer interface {
+	Ensure(path ...string) ref.Val
+}
+
+func EnsureOperation(args ...ref.Val) ref.Val {
+	e, ok := args[0].(ensurer)
+	if !ok {
+		return types.NoSuchOverloadErr()
+	}
+	path := make([]string, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		name, ok := arg.(types.String)
+		if !ok {
+			return types.MaybeNoSuchOverloadErr(arg)
+		}
+		path = append(path, string(name))
+	}
+	return e.Ensure(path...)
+}
+
+// ensureOverloads declares ensure with one to maxEnsurePathLength field
+// names. The receiver is dyn so that typed object mutators are accepted.
+func ensureOverloads() []cel.FunctionOpt {
+	var opts []cel.FunctionOpt
+	argTypes := []*cel.Type{cel.DynType}
+	for i := 1; i <= maxEnsurePathLength; i++ {
+		argTypes = append(argTypes, cel.StringType)
+		opts = append(opts, cel.MemberOverload(fmt.Sprintf("%s_%d", overloadNameObjectEnsure, i),
+			append([]*cel.Type{}, argTypes...), cel.DynType,
+			cel.FunctionBinding(EnsureOperation)))
+	}
+	return opts
+}
+
+// EnvOpts returns the options that declare the mutator functions. Objects
+// are held by object mutators, lists by lists that are mutators, and mutating
+// functions return null. The functions that the typed object mutators of
+// SchemaTypes share are bound once for all their overloads, which only serve
+// type checking. See receiverValidator for how lists are checked.
 func EnvOpts() []cel.EnvOption {
+	objectType := mutator.ObjectMutatorType
+	listType := mutator.ListMutatorTypeOf(cel.TypeParamType("T"))
 	return []cel.EnvOption{
-		cel.Function("merge",
-			cel.MemberOverload(overloadNameObjectMerge,
-				[]*cel.Type{mutator.ObjectMutatorType, cel.AnyType},
-				mutator.ObjectMutatorType, cel.BinaryBinding(MergeOperation)),
+		cel.Function("ensure", ensureOverloads()...),
+		// the receivers of set are dyn, so that both typed and untyped
+		// mutators are accepted.
+		cel.Function("set",
+			cel.MemberOverload(overloadNameSet,
+				[]*cel.Type{cel.DynType, cel.DynType}, cel.DynType,
+				cel.BinaryBinding(SetOperation)),
+			cel.MemberOverload(overloadNameObjectSetField,
+				[]*cel.Type{cel.DynType, cel.StringType, cel.DynType}, cel.DynType,
+				cel.FunctionBinding(SetFieldOperation)),
+			cel.MemberOverload(overloadNameListSetElement,
+				[]*cel.Type{cel.DynType, cel.IntType, cel.DynType}, cel.DynType,
+				cel.FunctionBinding(SetElementOperation)),
 		),
 		cel.Function("merge",
+			cel.MemberOverload(overloadNameObjectMerge,
+				[]*cel.Type{objectType, cel.AnyType}, cel.NullType),
 			cel.MemberOverload(overloadNameListMerge,
-				[]*cel.Type{mutator.ListMutatorType, cel.AnyType},
-				mutator.ListMutatorType, cel.BinaryBinding(MergeOperation)),
+				[]*cel.Type{listType, cel.AnyType}, cel.NullType),
+			cel.SingletonBinaryBinding(MergeOperation),
+		),
+		cel.Function("apply",
+			cel.MemberOverload(overloadNameObjectApply,
+				[]*cel.Type{objectType, cel.AnyType}, cel.NullType),
+			cel.MemberOverload(overloadNameListApply,
+				[]*cel.Type{listType, cel.AnyType}, cel.NullType),
+			cel.SingletonBinaryBinding(ApplyOperation),
 		),
 		cel.Function("remove",
 			cel.MemberOverload(overloadNameObjectRemove,
-				[]*cel.Type{mutator.ObjectMutatorType},
-				mutator.ObjectMutatorType,
-				cel.UnaryBinding(RemoveOperation),
-			)),
+				[]*cel.Type{objectType}, cel.NullType),
+			cel.MemberOverload(overloadNameListRemove,
+				[]*cel.Type{listType}, cel.NullType),
+			cel.MemberOverload(overloadNameObjectRemoveField,
+				[]*cel.Type{cel.DynType, cel.StringType}, cel.DynType),
+			cel.MemberOverload(overloadNameListRemoveElement,
+				[]*cel.Type{cel.DynType, cel.IntType}, cel.DynType),
+			cel.SingletonFunctionBinding(removeOperation),
+		),
+		cel.Function(functionField,
+			cel.Overload(overloadNameField,
+				[]*cel.Type{cel.BoolType, cel.StringType},
+				cel.StringType,
+				cel.BinaryBinding(FieldOperation),
+			),
+		),
+		cel.Function(functionElements,
+			cel.Overload(overloadNameListElements,
+				[]*cel.Type{listType},
+				cel.ListType(cel.DynType),
+				cel.UnaryBinding(ElementsOperation),
+			),
+		),
+		cel.Function(functionRemoveWhere,
+			cel.MemberOverload(overloadNameListRemoveWhere,
+				[]*cel.Type{listType, cel.ListType(cel.BoolType)},
+				cel.NullType,
+				cel.BinaryBinding(RemoveWhereOperation),
+			),
+		),
+		cel.ASTValidators(receiverValidator{}),
+		cel.Macros(
+			cel.NewReceiverMacro("removeWhere", 2, removeWhereMacro),
+			cel.NewReceiverMacro("set", 1, setMacro),
+			cel.NewReceiverMacro("remove", 0, removeMacro),
+		),
 	}
 }
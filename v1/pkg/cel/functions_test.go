@@ -0,0 +1,154 @@
+package cel
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/cel-go/cel"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/mutator"
+)
+
+func newUntypedEnv(t *testing.T) *cel.Env {
+	env, err := cel.NewEnv(append(EnvOpts(), cel.Variable("object", cel.DynType))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func TestSetRemoveMacros(t *testing.T) {
+	env := newUntypedEnv(t)
+	for _, tc := range []struct {
+		expression string
+		expanded   string
+	}{
+		{
+			expression: `object.spec.replicas.set(3)`,
+			expanded:   `object.spec.set(@field(has(object.spec.replicas), "replicas"), 3)`,
+		},
+		{
+			expression: `object.metadata.labels["team"].set("x")`,
+			expanded:   `object.metadata.labels.set("team", "x")`,
+		},
+		{
+			expression: `object.spec.containers[0].set({"name": "nginx"})`,
+			expanded:   `object.spec.containers.set(0, {"name": "nginx"})`,
+		},
+		{
+			expression: `object.spec.set("paused", true)`,
+			expanded:   `object.spec.set("paused", true)`,
+		},
+		{
+			expression: `object.spec.strategy.remove()`,
+			expanded:   `object.spec.remove(@field(has(object.spec.strategy), "strategy"))`,
+		},
+		{
+			expression: `object.spec.containers[1].remove()`,
+			expanded:   `object.spec.containers.remove(1)`,
+		},
+		{
+			expression: `object.set({"spec": {}})`,
+			expanded:   `object.set({"spec": {}})`,
+		},
+	} {
+		t.Run(tc.expression, func(t *testing.T) {
+			ast, issues := env.Parse(tc.expression)
+			if issues.Err() != nil {
+				t.Fatal(issues.Err())
+			}
+			expanded, err := cel.AstToString(ast)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expanded != tc.expanded {
+				t.Errorf("expected %s but got %s", tc.expanded, expanded)
+			}
+		})
+	}
+}
+
+func TestSetScalars(t *testing.T) {
+	env := newUntypedEnv(t)
+	object := map[string]any{
+		"spec": map[string]any{"replicas": int64(1), "paused": false},
+	}
+	for _, expression := range []string{
+		`object.spec.replicas.set(3)`,
+		`object.spec.paused.set(true)`,
+		`object.spec.ratio.set(0.5)`,
+		`object.spec.strategy.set("Recreate")`,
+		`object.spec.selector.set(null)`,
+	} {
+		ast, issues := env.Compile(expression)
+		if issues.Err() != nil {
+			t.Fatalf("%s: %v", expression, issues.Err())
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			t.Fatalf("%s: %v", expression, err)
+		}
+		if _, _, err := program.Eval(map[string]any{"object": mutator.NewRootObjectMutator(object)}); err != nil {
+			t.Errorf("%s: %v", expression, err)
+		}
+	}
+	expected := map[string]any{
+		"replicas": int64(3),
+		"paused":   true,
+		"ratio":    0.5,
+		"strategy": "Recreate",
+		"selector": nil,
+	}
+	if !reflect.DeepEqual(object["spec"], expected) {
+		t.Errorf("expected %v but got %v", expected, object["spec"])
+	}
+}
+
+func TestMutatorReceivers(t *testing.T) {
+	env := newUntypedEnv(t)
+	for _, tc := range []struct {
+		expression    string
+		expectedError string
+	}{
+		{
+			expression: `object.spec.merge({"replicas": 3})`,
+		},
+		{
+			expression: `object.spec.containers.merge([{"name": "sidecar"}])`,
+		},
+		{
+			expression:    `{"a": 1}.merge({})`,
+			expectedError: "found no matching overload for 'merge'",
+		},
+		{
+			expression:    `{"a": 1}.apply({})`,
+			expectedError: "found no matching overload for 'apply'",
+		},
+		{
+			expression:    `[1, 2].merge([3])`,
+			expectedError: "merge() cannot mutate a list(int) that is not read from a variable",
+		},
+		{
+			expression:    `object.spec.containers.filter(c, c.name == "nginx").remove()`,
+			expectedError: "remove() cannot mutate a list(dyn) that is not read from a variable",
+		},
+		{
+			expression:    `{"a": 1}.a.set(2)`,
+			expectedError: "set() cannot mutate a map(string, int) that is not read from a variable",
+		},
+	} {
+		t.Run(tc.expression, func(t *testing.T) {
+			_, issues := env.Compile(tc.expression)
+			if tc.expectedError == "" {
+				if issues.Err() != nil {
+					t.Fatal(issues.Err())
+				}
+				return
+			}
+			if issues.Err() == nil || !strings.Contains(issues.Err().Error(), tc.expectedError) {
+				t.Fatalf("expected error %q but got %v", tc.expectedError, issues.Err())
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package cel
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/apply"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/mutator"
+)
+
+// SchemaTypes holds the CEL types derived from a schema. Objects with known
+// properties become object mutator types with typed fields, lists become
+// list mutator types of their element types, and scalars become their CEL counterparts.
+// Everything else is dyn.
+type SchemaTypes struct {
+	root    *cel.Type
+	objects map[string]map[string]*types.FieldType
+}
+
+// NewSchemaTypes derives the CEL types from the schema at which the tracker
+// is. The references within the schema are resolved through the tracker, and
+// a reference back to a schema that is being resolved becomes dyn. The name
+// is used to qualify the names of the derived object mutator types.
+func NewSchemaTypes(name string, tracker *apply.SchemaTracker) (*SchemaTypes, error) {
+	schema, err := tracker.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	t := &SchemaTypes{objects: make(map[string]map[string]*types.FieldType)}
+	t.root = t.typeOf(name, schema)
+	return t, nil
+}
+
+// Root returns the type of the object that the schema describes.
+func (t *SchemaTypes) Root() *cel.Type {
+	return t.root
+}
+
+// EnvOpts returns the options that declare the derived types and the
+// mutator functions on them. The functions are bound by the options of the
+// package-level EnvOpts, which must be declared as well.
+func (t *SchemaTypes) EnvOpts() []cel.EnvOption {
+	opts := []cel.EnvOption{
+		func(e *cel.Env) (*cel.Env, error) {
+			return cel.CustomTypeProvider(&schemaTypeProvider{Provider: e.CELTypeProvider(), types: t})(e)
+		},
+	}
+	for _, name := range t.objectTypeNames() {
+		objectType := mutator.ObjectMutatorTypeOf(name)
+		opts = append(opts,
+			cel.Function("merge",
+				cel.MemberOverload(fmt.Sprintf("%s_%s", overloadNameObjectMerge, name),
+					[]*cel.Type{objectType, cel.AnyType}, cel.NullType),
+			),
+			cel.Function("apply",
+				cel.MemberOverload(fmt.Sprintf("%s_%s", overloadNameObjectApply, name),
+					[]*cel.Type{objectType, cel.AnyType}, cel.NullType),
+			),
+			cel.Function("remove",
+				cel.MemberOverload(fmt.Sprintf("%s_%s", overloadNameObjectRemove, name),
+					[]*cel.Type{objectType}, cel.NullType),
+			),
+		)
+	}
+	return opts
+}
+
+func (t *SchemaTypes) objectTypeNames() []string {
+	names := make([]string, 0, len(t.objects))
+	for name := range t.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (t *SchemaTypes) typeOf(name string, s *spec.Schema) *cel.Type {
+	if s == nil || len(s.Type) == 0 {
+		return cel.DynType
+	}
+	switch s.Type[0] {
+	case "object":
+		if len(s.Properties) == 0 || s.AdditionalProperties != nil {
+			return cel.DynType
+		}
+		if preserve, _ := s.Extensions.GetBool("x-kubernetes-preserve-unknown-fields"); preserve {
+			return cel.DynType
+		}
+		fields := make(map[string]*types.FieldType, len(s.Properties))
+		t.objects[name] = fields
+		for fieldName, fieldSchema := range s.Properties {
+			fieldSchema := fieldSchema
+			fields[fieldName] = &types.FieldType{Type: t.typeOf(name+"."+fieldName, &fieldSchema)}
+		}
+		return mutator.ObjectMutatorTypeOf(name)
+	case "array":
+		var items *spec.Schema
+		if s.Items != nil {
+			items = s.Items.Schema
+		}
+		return mutator.ListMutatorTypeOf(t.typeOf(name+".@idx", items))
+	case "string":
+		if intOrString, _ := s.Extensions.GetBool("x-kubernetes-int-or-string"); intOrString {
+			return cel.DynType
+		}
+		return cel.StringType
+	case "integer":
+		return cel.IntType
+	case "number":
+		return cel.DoubleType
+	case "boolean":
+		return cel.BoolType
+	}
+	return cel.DynType
+}
+
+// schemaTypeProvider resolves the derived object mutator types and delegates
+// everything else to the provider of the environment.
+type schemaTypeProvider struct {
+	types.Provider
+	types *SchemaTypes
+}
+
+func (p *schemaTypeProvider) FindStructType(structType string) (*types.Type, bool) {
+	if name, ok := mutator.ObjectMutatorTypeParameter(structType); ok {
+		if _, ok := p.types.objects[name]; ok {
+			return types.NewTypeTypeWithParam(mutator.ObjectMutatorTypeOf(name)), true
+		}
+	}
+	return p.Provider.FindStructType(structType)
+}
+
+func (p *schemaTypeProvider) FindStructFieldType(structType, fieldName string) (*types.FieldType, bool) {
+	if name, ok := mutator.ObjectMutatorTypeParameter(structType); ok {
+		if fields, ok := p.types.objects[name]; ok {
+			f, ok := fields[fieldName]
+			return f, ok
+		}
+	}
+	return p.Provider.FindStructFieldType(structType, fieldName)
+}
+
+func (p *schemaTypeProvider) NewValue(structType string, fields map[string]ref.Val) ref.Val {
+	return p.Provider.NewValue(structType, fields)
+}
@@ -0,0 +1,181 @@
+package cel
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/cel-go/cel"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/apply"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/openapi"
+)
+
+func newTypedEnv(t *testing.T) *cel.Env {
+	f, err := os.Open("../../testdata/deploy.schema.json")
+	if err != nil {
+		t.Fatalf("cannot load schema file: %v", err)
+	}
+	defer f.Close()
+	s, err := openapi.LoadSchema(f)
+	if err != nil {
+		t.Fatalf("cannot load schema: %v", err)
+	}
+	return newEnvWithTracker(t, apply.NewSchemaTracker(s, nil))
+}
+
+func newEnvWithTracker(t *testing.T, tracker *apply.SchemaTracker) *cel.Env {
+	schemaTypes, err := NewSchemaTypes("Deployment", tracker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := cel.NewEnv(append(EnvOpts(), schemaTypes.EnvOpts()...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err = env.Extend(cel.Variable("object", schemaTypes.Root()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func TestSchemaTypes(t *testing.T) {
+	env := newTypedEnv(t)
+	for _, tc := range []struct {
+		expression    string
+		expectedType  *cel.Type
+		expectedError string
+	}{
+		{
+			expression:   `object.spec.merge({"replicas": 3})`,
+			expectedType: cel.NullType,
+		},
+		{
+			expression:   `object.spec.replicas`,
+			expectedType: cel.IntType,
+		},
+		{
+			expression:   `object.spec.template.spec.containers[0].image`,
+			expectedType: cel.StringType,
+		},
+		{
+			expression:   `object.spec.template.spec.containers[0].merge({"image": "nginx:latest"})`,
+			expectedType: cel.NullType,
+		},
+		{
+			expression:   `object.spec.template.spec.containers.merge([{"name": "sidecar"}])`,
+			expectedType: cel.NullType,
+		},
+		{
+			expression:   `object.metadata.labels`,
+			expectedType: cel.DynType,
+		},
+		{
+			expression:    `object.spec.replcas.merge({"replicas": 3})`,
+			expectedError: "undefined field 'replcas'",
+		},
+		{
+			expression:   `object.spec.replicas.set(3)`,
+			expectedType: cel.DynType,
+		},
+		{
+			expression:    `object.spec.replcas.set(3)`,
+			expectedError: "undefined field 'replcas'",
+		},
+		{
+			expression:    `object.spec.strategy.rollingUpdat.remove()`,
+			expectedError: "undefined field 'rollingUpdat'",
+		},
+		{
+			expression:    `object.spec.replicas.merge({"replicas": 3})`,
+			expectedError: "found no matching overload for 'merge'",
+		},
+		{
+			expression:    `object.spec.template.spec.containers["nginx"]`,
+			expectedError: "found no matching overload for '_[_]'",
+		},
+	} {
+		t.Run(tc.expression, func(t *testing.T) {
+			ast, issues := env.Compile(tc.expression)
+			if tc.expectedError != "" {
+				if issues == nil || !strings.Contains(issues.Err().Error(), tc.expectedError) {
+					t.Fatalf("expected error %q but got %v", tc.expectedError, issues)
+				}
+				return
+			}
+			if issues != nil {
+				t.Fatal(issues.Err())
+			}
+			if !ast.OutputType().IsExactType(tc.expectedType) {
+				t.Errorf("expected type %v but got %v", tc.expectedType, ast.OutputType())
+			}
+		})
+	}
+}
+
+func TestSchemaTypesResolveReferences(t *testing.T) {
+	components := map[string]*spec.Schema{
+		"Deployment": {SchemaProps: spec.SchemaProps{
+			Type: spec.StringOrArray{"object"},
+			Properties: map[string]spec.Schema{
+				"spec": *apply.ComponentRef("DeploymentSpec"),
+			},
+		}},
+		"DeploymentSpec": {SchemaProps: spec.SchemaProps{
+			Type: spec.StringOrArray{"object"},
+			Properties: map[string]spec.Schema{
+				"replicas": *spec.Int64Property(),
+				"selector": *apply.ComponentRef("Selector"),
+			},
+		}},
+		"Selector": {SchemaProps: spec.SchemaProps{
+			Type: spec.StringOrArray{"object"},
+			Properties: map[string]spec.Schema{
+				"matchLabels": *spec.MapProperty(spec.StringProperty()),
+				"and":         *spec.ArrayProperty(apply.ComponentRef("Selector")),
+			},
+		}},
+	}
+	env := newEnvWithTracker(t, apply.NewSchemaTracker(apply.ComponentRef("Deployment"), components))
+	for _, tc := range []struct {
+		expression    string
+		expectedType  *cel.Type
+		expectedError string
+	}{
+		{
+			expression:   `object.spec.replicas`,
+			expectedType: cel.IntType,
+		},
+		{
+			expression:   `object.spec.selector.merge({"matchLabels": {"app": "nginx"}})`,
+			expectedType: cel.NullType,
+		},
+		{
+			// the reference back to the selector is not followed.
+			expression:   `object.spec.selector.and[0]`,
+			expectedType: cel.DynType,
+		},
+		{
+			expression:    `object.spec.replcas`,
+			expectedError: "undefined field 'replcas'",
+		},
+	} {
+		t.Run(tc.expression, func(t *testing.T) {
+			ast, issues := env.Compile(tc.expression)
+			if tc.expectedError != "" {
+				if issues == nil || !strings.Contains(issues.Err().Error(), tc.expectedError) {
+					t.Fatalf("expected error %q but got %v", tc.expectedError, issues)
+				}
+				return
+			}
+			if issues != nil {
+				t.Fatal(issues.Err())
+			}
+			if !ast.OutputType().IsExactType(tc.expectedType) {
+				t.Errorf("expected type %v but got %v", tc.expectedType, ast.OutputType())
+			}
+		})
+	}
+}
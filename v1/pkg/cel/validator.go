@@ -0,0 +1,64 @@
+package cel
+
+import (
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/types"
+)
+
+// mutatingFunctions are the functions that mutate their receivers.
+var mutatingFunctions = []string{"merge", "apply", "remove", "set", "ensure", functionRemoveWhere}
+
+// receiverValidator rejects the calls of mutating functions on lists and maps
+// that are not read from variables, such as literals and the results of
+// macros, because only the values read from variables can be mutators.
+// Object mutators are told apart from maps by their type, but list mutators
+// are lists to the type checker, so that they are checked here.
+type receiverValidator struct{}
+
+func (receiverValidator) Name() string {
+	return "mutator.validate.receivers"
+}
+
+func (receiverValidator) Validate(_ *cel.Env, _ cel.ValidatorConfig, a *ast.CheckedAST, iss *cel.Issues) {
+	root := ast.NavigateCheckedAST(a)
+	for _, name := range mutatingFunctions {
+		for _, call := range ast.MatchDescendants(root, ast.FunctionMatcher(name)) {
+			target := call.AsCall().Target()
+			if target == nil {
+				continue
+			}
+			switch target.Type().Kind() {
+			case types.ListKind, types.MapKind:
+			default:
+				continue
+			}
+			if !isReadFromVariable(target) {
+				iss.ReportErrorAtID(target.ID(), "%s() cannot mutate a %s that is not read from a variable",
+					name, target.Type())
+			}
+		}
+	}
+}
+
+// isReadFromVariable returns whether the expression is a variable, or a
+// selection or an index of which the operand is read from a variable.
+func isReadFromVariable(e ast.NavigableExpr) bool {
+	for {
+		switch e.Kind() {
+		case ast.IdentKind:
+			return true
+		case ast.SelectKind:
+			e = e.AsSelect().Operand()
+		case ast.CallKind:
+			call := e.AsCall()
+			if call.FunctionName() != operators.Index || len(call.Args()) != 2 {
+				return false
+			}
+			e = call.Args()[0]
+		default:
+			return false
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package evaluator
+
+import (
+	"github.com/google/cel-go/interpreter"
+	"k8s.io/apiserver/pkg/cel/lazy"
+)
+
+type activation struct {
+	variables *lazy.MapValue
+	object    any
+}
+
+func (a *activation) ResolveName(name string) (any, bool) {
+	switch name {
+	case ObjectVarName:
+		return a.object, true
+	case VariablesVarName:
+		return a.variables, true
+	default:
+		return nil, false
+	}
+}
+
+func (a *activation) Parent() interpreter.Activation {
+	return nil
+}
+
+var _ interpreter.Activation = (*activation)(nil)
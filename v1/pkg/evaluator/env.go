@@ -0,0 +1,57 @@
+package evaluator
+
+import (
+	"github.com/google/cel-go/cel"
+	"k8s.io/apimachinery/pkg/util/version"
+	apiservercel "k8s.io/apiserver/pkg/cel"
+	"k8s.io/apiserver/pkg/cel/environment"
+
+	mutatorcel "github.com/jiahuif/cel-mutating-experiments/v1/pkg/cel"
+)
+
+const (
+	ObjectVarName    = "object"
+	VariablesVarName = "variables"
+)
+
+var variablesType = apiservercel.NewMapType(apiservercel.StringType, apiservercel.AnyType, 0)
+
+func init() {
+	variablesType.Fields = make(map[string]*apiservercel.DeclField)
+}
+
+// NewEnv creates the CEL environment that mutation expressions are compiled
+// against, with "object" and "variables" declared.
+func NewEnv() (*cel.Env, error) {
+	return newEnv(cel.DynType)
+}
+
+// NewTypedEnv creates the CEL environment like NewEnv, except that "object"
+// is declared with the types derived from a schema, so that expressions are
+// type-checked against the structure of the object.
+func NewTypedEnv(schemaTypes *mutatorcel.SchemaTypes) (*cel.Env, error) {
+	return newEnv(schemaTypes.Root(), schemaTypes.EnvOpts()...)
+}
+
+func newEnv(objectType *cel.Type, opts ...cel.EnvOption) (*cel.Env, error) {
+	envSet, err := environment.MustBaseEnvSet(environment.DefaultCompatibilityVersion()).Extend(
+		environment.VersionedOptions{
+			IntroducedVersion: version.MajorMinor(1, 28),
+			EnvOptions: append([]cel.EnvOption{
+				cel.Variable(VariablesVarName, variablesType.CelType()),
+			}, mutatorcel.EnvOpts()...),
+			DeclTypes: []*apiservercel.DeclType{
+				variablesType,
+			},
+		})
+	if err != nil {
+		return nil, err
+	}
+	env, err := envSet.Env(environment.StoredExpressions)
+	if err != nil {
+		return nil, err
+	}
+	// extend afterward, because the env set replaces the type provider with
+	// its own when it has declared types.
+	return env.Extend(append(opts, cel.Variable(ObjectVarName, objectType))...)
+}
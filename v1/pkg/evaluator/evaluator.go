@@ -0,0 +1,276 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"k8s.io/apiserver/pkg/cel/lazy"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"sigs.k8s.io/structured-merge-diff/v4/merge"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/api"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/apply"
+	mutatorcel "github.com/jiahuif/cel-mutating-experiments/v1/pkg/cel"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/mutator"
+)
+
+// ErrUnnamedPolicy is returned for a policy without a name, which cannot
+// act as a field manager.
+var ErrUnnamedPolicy = errors.New("policy has no name")
+
+// rootTypeName qualifies the names of the types derived from the schema.
+const rootTypeName = "Object"
+
+// PolicyEvaluator holds the compiled programs of a MutatingAdmissionPolicy.
+// It is safe to reuse a PolicyEvaluator across objects.
+type PolicyEvaluator struct {
+	policy *api.MutatingAdmissionPolicy
+	// tracker is at the schema of the objects, or nil if it is unknown.
+	// It is shared by all objects, so that the structured-merge-diff type
+	// of the schema is created once.
+	tracker   *apply.SchemaTracker
+	variables []compiledVariable
+	mutations []compiledMutation
+}
+
+type compiledVariable struct {
+	name    string
+	program cel.Program
+}
+
+type compiledMutation struct {
+	// condition is nil if the mutation has no condition.
+	condition   cel.Program
+	expressions []compiledExpression
+}
+
+type compiledExpression struct {
+	expression string
+	program    cel.Program
+}
+
+// Result is the outcome of applying a policy to an object.
+type Result struct {
+	Mutations []MutationResult
+
+	// Patch is the JSONPatch that turns the original object into the
+	// mutated one.
+	Patch mutator.JSONPatch
+
+	// Conflicts holds the fields that the policy has applied, and changed,
+	// but that have been managed by other field managers. The policy takes
+	// over the ownership of such fields, like a forced server-side apply.
+	Conflicts merge.Conflicts
+}
+
+// MutationResult is the outcome of a single mutation block.
+type MutationResult struct {
+	// Skipped is true if the condition of the mutation evaluated to false.
+	Skipped bool
+
+	// Expressions holds the result of each expression that has been evaluated,
+	// in order.
+	Expressions []ExpressionResult
+}
+
+// ExpressionResult is the outcome of a single mutation expression.
+type ExpressionResult struct {
+	Expression string
+	Value      ref.Val
+	Error      error
+
+	// Patch holds the changes made by the expression.
+	Patch mutator.JSONPatch
+}
+
+// Option configures a PolicyEvaluator.
+type Option func(e *PolicyEvaluator)
+
+// WithSchema makes the evaluator mutate objects with the knowledge of
+// the given schema, e.g. to merge associative lists by their keys.
+// Expressions are also type-checked against the schema.
+func WithSchema(schema *spec.Schema) Option {
+	return func(e *PolicyEvaluator) {
+		e.tracker = nil
+		if schema != nil {
+			e.tracker = apply.NewSchemaTracker(schema, nil)
+		}
+	}
+}
+
+// WithSchemaTracker is like WithSchema, but takes a tracker at the schema,
+// through which the references within the schema are resolved.
+func WithSchemaTracker(tracker *apply.SchemaTracker) Option {
+	return func(e *PolicyEvaluator) {
+		e.tracker = tracker
+	}
+}
+
+// NewPolicyEvaluator compiles all variables, conditions and expressions of
+// the given policy. All compilation errors are reported together. The policy
+// must have a name, because the evaluator manages the fields that it applies
+// by the name. See FieldManager.
+func NewPolicyEvaluator(policy *api.MutatingAdmissionPolicy, opts ...Option) (*PolicyEvaluator, error) {
+	if policy.Name == "" {
+		return nil, ErrUnnamedPolicy
+	}
+	e := &PolicyEvaluator{policy: policy}
+	for _, opt := range opts {
+		opt(e)
+	}
+	env, err := e.env()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create environment: %w", err)
+	}
+	var errs []error
+	for i, v := range policy.Spec.Variables {
+		prog, err := compile(env, v.Expression, cel.AnyType)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("spec.variables[%d]: %w", i, err))
+			continue
+		}
+		e.variables = append(e.variables, compiledVariable{name: v.Name, program: prog})
+	}
+	for i, m := range policy.Spec.Mutation {
+		compiled := compiledMutation{}
+		if m.Condition != "" {
+			prog, err := compile(env, string(m.Condition), cel.BoolType)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("spec.mutation[%d].condition: %w", i, err))
+			}
+			compiled.condition = prog
+		}
+		for j, exp := range m.Expressions {
+			prog, err := compile(env, exp, cel.AnyType)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("spec.mutation[%d].expressions[%d]: %w", i, j, err))
+				continue
+			}
+			compiled.expressions = append(compiled.expressions, compiledExpression{expression: exp, program: prog})
+		}
+		e.mutations = append(e.mutations, compiled)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return e, nil
+}
+
+func (e *PolicyEvaluator) env() (*cel.Env, error) {
+	if e.tracker == nil {
+		return NewEnv()
+	}
+	schemaTypes, err := mutatorcel.NewSchemaTypes(rootTypeName, e.tracker)
+	if err != nil {
+		return nil, err
+	}
+	return NewTypedEnv(schemaTypes)
+}
+
+// Policy returns the policy that the evaluator is compiled from.
+func (e *PolicyEvaluator) Policy() *api.MutatingAdmissionPolicy {
+	return e.policy
+}
+
+// Apply runs all mutations of the policy against the given object, mutating
+// it in place. The mutations are all-or-nothing: evaluation stops at the
+// first error, which is both returned and recorded in the result, and the
+// object is rolled back to its original state. The result also carries the
+// changes as a JSONPatch, which is empty if the policy fails. The fields
+// set through apply() are recorded in metadata.managedFields, with the policy
+// as their field manager.
+func (e *PolicyEvaluator) Apply(object map[string]any) (*Result, error) {
+	root := mutator.NewRootObjectMutatorWithTracker(object, e.tracker)
+	tx, err := mutator.Begin(root)
+	if err != nil {
+		return nil, err
+	}
+	a := &activation{
+		variables: lazy.NewMapValue(variablesType),
+		object:    root,
+	}
+	for _, v := range e.variables {
+		program := v.program
+		a.variables.Append(v.name, func(*lazy.MapValue) ref.Val {
+			val, _, err := program.Eval(a)
+			if err != nil {
+				return types.WrapErr(err)
+			}
+			return val
+		})
+	}
+	result := &Result{}
+	if err := e.run(root, a, result); err != nil {
+		tx.Rollback()
+		return result, err
+	}
+	if applied := mutator.AppliedFieldsOf(root); applied != nil {
+		result.Conflicts, err = e.manageFields(root, tx.Snapshot(), object, applied)
+		if err != nil {
+			tx.Rollback()
+			return result, fmt.Errorf("metadata.managedFields: %w", err)
+		}
+	}
+	result.Patch = mutator.JSONPatchOf(root)
+	return result, nil
+}
+
+func (e *PolicyEvaluator) run(root mutator.Interface, a *activation, result *Result) error {
+	for i, m := range e.mutations {
+		mutationResult := MutationResult{}
+		if m.condition != nil {
+			v, _, err := m.condition.Eval(a)
+			if err != nil {
+				result.Mutations = append(result.Mutations, mutationResult)
+				return fmt.Errorf("spec.mutation[%d].condition: %w", i, err)
+			}
+			matched, ok := v.(types.Bool)
+			if !ok {
+				result.Mutations = append(result.Mutations, mutationResult)
+				return fmt.Errorf("spec.mutation[%d].condition: expect bool but got %v", i, v.Type())
+			}
+			if !matched {
+				mutationResult.Skipped = true
+				result.Mutations = append(result.Mutations, mutationResult)
+				continue
+			}
+		}
+		for j, exp := range m.expressions {
+			before := len(mutator.JSONPatchOf(root))
+			v, _, err := exp.program.Eval(a)
+			if err != nil {
+				err = fmt.Errorf("spec.mutation[%d].expressions[%d]: %w", i, j, err)
+			}
+			mutationResult.Expressions = append(mutationResult.Expressions, ExpressionResult{
+				Expression: exp.expression,
+				Value:      v,
+				Error:      err,
+				Patch:      mutator.JSONPatchOf(root)[before:],
+			})
+			if err != nil {
+				result.Mutations = append(result.Mutations, mutationResult)
+				return err
+			}
+		}
+		result.Mutations = append(result.Mutations, mutationResult)
+	}
+	return nil
+}
+
+func compile(env *cel.Env, exp string, outputType *cel.Type) (cel.Program, error) {
+	ast, issues := env.Compile(exp)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("fail to compile: %w", issues.Err())
+	}
+	if outputType != cel.AnyType && !ast.OutputType().IsExactType(outputType) && !ast.OutputType().IsExactType(cel.DynType) {
+		return nil, fmt.Errorf("expect %v but got %v", outputType, ast.OutputType())
+	}
+	prog, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create program: %w", err)
+	}
+	return prog, nil
+}
@@ -0,0 +1,239 @@
+package evaluator
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/api"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/openapi"
+)
+
+func newPolicy(variables []api.Variable, mutations ...api.Mutation) *api.MutatingAdmissionPolicy {
+	policy := new(api.MutatingAdmissionPolicy)
+	policy.Name = "test.policy.example.com"
+	policy.Spec.Variables = variables
+	policy.Spec.Mutation = mutations
+	return policy
+}
+
+func newObject() map[string]any {
+	return map[string]any{
+		"spec": map[string]any{
+			"replicas": int64(1),
+		},
+	}
+}
+
+func TestApplyReusable(t *testing.T) {
+	e, err := NewPolicyEvaluator(newPolicy(nil, api.Mutation{
+		Expressions: []string{`object.spec.merge({"replicas": 3})`},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		object := newObject()
+		result, err := e.Apply(object)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Mutations) != 1 || len(result.Mutations[0].Expressions) != 1 {
+			t.Fatalf("unexpected result: %v", result)
+		}
+		if object["spec"].(map[string]any)["replicas"] != int64(3) {
+			t.Errorf("unexpected object: %v", object)
+		}
+	}
+}
+
+func TestApplyVariables(t *testing.T) {
+	e, err := NewPolicyEvaluator(newPolicy([]api.Variable{
+		{Name: "replicas", Expression: "1 + 2"},
+	}, api.Mutation{
+		Expressions: []string{`object.spec.merge({"replicas": variables.replicas})`},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	object := newObject()
+	if _, err := e.Apply(object); err != nil {
+		t.Fatal(err)
+	}
+	if object["spec"].(map[string]any)["replicas"] != int64(3) {
+		t.Errorf("unexpected object: %v", object)
+	}
+}
+
+func TestApplyJSONTypes(t *testing.T) {
+	e, err := NewPolicyEvaluator(newPolicy([]api.Variable{
+		{Name: "patch", Expression: `{"paused": dyn(true), "ratio": dyn(0.5), "selector": dyn(null)}`},
+	}, api.Mutation{
+		Expressions: []string{
+			`object.spec.merge(variables.patch)`,
+			`object.spec.merge({"args": ["a"] + [dyn(null), dyn(1u), dyn(b"b")]})`,
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	object := newObject()
+	object["spec"].(map[string]any)["selector"] = map[string]any{}
+	if _, err := e.Apply(object); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]any{
+		"replicas": int64(1),
+		"paused":   true,
+		"ratio":    0.5,
+		"args":     []any{"a", nil, int64(1), "Yg=="},
+	}
+	if !reflect.DeepEqual(object["spec"], expected) {
+		t.Errorf("expected %v but got %v", expected, object["spec"])
+	}
+}
+
+func TestApplyReadValues(t *testing.T) {
+	e, err := NewPolicyEvaluator(newPolicy(nil, api.Mutation{
+		Expressions: []string{
+			`object.spec.replicas < 2 ? object.spec.merge({"replicas": 2}) : null`,
+			`has(object.spec.paused) ? null : object.spec.paused.set(object.spec.replicas == 2)`,
+			`object.spec == {"replicas": dyn(2), "paused": dyn(true), "args": dyn(["a", "b"])} ? object.spec.set("equal", true) : null`,
+			`size(object.spec.args) == 2 && object.spec.args.exists(a, a == "b") ? object.spec.args[0].set("c") : null`,
+			`object.spec.merge({"command": object.spec.args.map(a, a + "!")})`,
+			`"b!" in object.spec.command ? object.spec.command[1].remove() : null`,
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	object := newObject()
+	object["spec"].(map[string]any)["args"] = []any{"a", "b"}
+	if _, err := e.Apply(object); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]any{
+		"replicas": int64(2),
+		"paused":   true,
+		"equal":    true,
+		"args":     []any{"c", "b"},
+		"command":  []any{"c!"},
+	}
+	if !reflect.DeepEqual(object["spec"], expected) {
+		t.Errorf("expected %v but got %v", expected, object["spec"])
+	}
+}
+
+func TestApplyOptionalFields(t *testing.T) {
+	e, err := NewPolicyEvaluator(newPolicy(nil, api.Mutation{
+		Expressions: []string{
+			`has(object.spec.strategy) ? null : object.spec.set("strategy", {"type": "Recreate"})`,
+			`object.spec.?paused.orValue(false) ? null : object.spec.paused.set(true)`,
+			`object.?status.?replicas.hasValue() ? object.status.remove() : null`,
+			`object.spec[?"replicas"].orValue(0) == 1 ? object.spec.replicas.set(2) : null`,
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	object := newObject()
+	if _, err := e.Apply(object); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]any{
+		"spec": map[string]any{
+			"replicas": int64(2),
+			"paused":   true,
+			"strategy": map[string]any{"type": "Recreate"},
+		},
+	}
+	if !reflect.DeepEqual(object, expected) {
+		t.Errorf("expected %v but got %v", expected, object)
+	}
+}
+
+func TestApplyError(t *testing.T) {
+	e, err := NewPolicyEvaluator(newPolicy(nil, api.Mutation{
+		Expressions: []string{
+			`object.spec.merge({"replicas": 3})`,
+			`object.spec.strategy.remove()`,
+			`object.spec.merge({"replicas": 5})`,
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	object := newObject()
+	result, err := e.Apply(object)
+	if err == nil || !strings.Contains(err.Error(), "spec.mutation[0].expressions[1]") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expressions := result.Mutations[0].Expressions
+	if len(expressions) != 2 || expressions[0].Error != nil || expressions[1].Error == nil {
+		t.Errorf("unexpected expression results: %v", expressions)
+	}
+	if !reflect.DeepEqual(object, newObject()) {
+		t.Errorf("expected the object to be rolled back but got %v", object)
+	}
+	if len(result.Patch) != 0 {
+		t.Errorf("expected no patch but got %v", result.Patch)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	_, err := NewPolicyEvaluator(newPolicy(nil, api.Mutation{
+		Condition:   "1 + 1",
+		Expressions: []string{`object.spec.merge(`},
+	}))
+	if err == nil {
+		t.Fatal("expected compile error")
+	}
+	for _, path := range []string{"spec.mutation[0].condition", "spec.mutation[0].expressions[0]"} {
+		if !strings.Contains(err.Error(), path) {
+			t.Errorf("expected error to mention %q, but got %v", path, err)
+		}
+	}
+	// only the values read from variables can be mutated.
+	_, err = NewPolicyEvaluator(newPolicy(nil, api.Mutation{
+		Expressions: []string{`[1, 2].merge([3])`},
+	}))
+	if err == nil || !strings.Contains(err.Error(), "not read from a variable") {
+		t.Errorf("expected the receiver to be rejected, but got %v", err)
+	}
+	unnamed := newPolicy(nil, api.Mutation{Expressions: []string{`object.spec.merge({})`}})
+	unnamed.Name = ""
+	if _, err := NewPolicyEvaluator(unnamed); !errors.Is(err, ErrUnnamedPolicy) {
+		t.Errorf("expected ErrUnnamedPolicy but got %v", err)
+	}
+}
+
+func BenchmarkApply(b *testing.B) {
+	f, err := os.Open("../../testdata/deploy.schema.json")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	schema, err := openapi.LoadSchema(f)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, expression := range []string{
+		`object.spec.merge({"replicas": 3})`,
+		`object.spec.apply({"replicas": 3})`,
+	} {
+		b.Run(expression, func(b *testing.B) {
+			e, err := NewPolicyEvaluator(newPolicy(nil, api.Mutation{Expressions: []string{expression}}), WithSchema(schema))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := e.Apply(newObject()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
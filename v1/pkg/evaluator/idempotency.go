@@ -0,0 +1,72 @@
+package evaluator
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/mutator"
+)
+
+// NonIdempotentExpression is an expression that changes the object again, or
+// fails, when its policy is applied to an object that the policy has mutated.
+type NonIdempotentExpression struct {
+	// Mutation and Index locate the expression in spec.mutation.
+	Mutation int
+	Index    int
+
+	Expression string
+
+	// Patch holds the changes that the expression makes on the second run.
+	Patch mutator.JSONPatch
+
+	// Error is the error of the expression on the second run, if it fails,
+	// e.g. because it removes a field that the first run has removed.
+	Error error
+}
+
+func (n *NonIdempotentExpression) String() string {
+	if n.Error != nil {
+		return fmt.Sprintf("fails on reinvocation: %v", n.Error)
+	}
+	return fmt.Sprintf("spec.mutation[%d].expressions[%d]: %d more change(s) on reinvocation", n.Mutation, n.Index, len(n.Patch))
+}
+
+// CheckIdempotency applies the policy twice to a copy of the object, and
+// reports the expressions that change the object on the second run. Such
+// expressions, e.g. appending to a list that has no keys, keep changing the
+// object whenever the policy is reinvoked. An expression that fails on the
+// second run is reported as well, with the changes of the expressions before
+// it. The error is for a policy that fails on the first run, or on a
+// condition. The given object is not modified.
+func (e *PolicyEvaluator) CheckIdempotency(object map[string]any) ([]*NonIdempotentExpression, error) {
+	object = runtime.DeepCopyJSON(object)
+	if _, err := e.Apply(object); err != nil {
+		return nil, err
+	}
+	result, err := e.Apply(object)
+	if result == nil {
+		return nil, fmt.Errorf("on reinvocation: %w", err)
+	}
+	var nonIdempotent []*NonIdempotentExpression
+	failed := false
+	for i, m := range result.Mutations {
+		for j, exp := range m.Expressions {
+			if len(exp.Patch) == 0 && exp.Error == nil {
+				continue
+			}
+			failed = failed || exp.Error != nil
+			nonIdempotent = append(nonIdempotent, &NonIdempotentExpression{
+				Mutation:   i,
+				Index:      j,
+				Expression: exp.Expression,
+				Patch:      exp.Patch,
+				Error:      exp.Error,
+			})
+		}
+	}
+	if err != nil && !failed {
+		return nil, fmt.Errorf("on reinvocation: %w", err)
+	}
+	return nonIdempotent, nil
+}
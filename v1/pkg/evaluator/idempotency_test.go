@@ -0,0 +1,74 @@
+package evaluator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/api"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/mutator"
+)
+
+func TestCheckIdempotency(t *testing.T) {
+	e, err := NewPolicyEvaluator(newPolicy(nil, api.Mutation{
+		Expressions: []string{
+			`object.spec.merge({"args": ["a"]})`,
+			`object.spec.args.merge(["b"])`,
+			`object.spec.paused.set(true)`,
+		},
+	}, api.Mutation{
+		Expressions: []string{
+			`object.spec.merge({"replicas": object.spec.replicas + 1})`,
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	object := newObject()
+	nonIdempotent, err := e.CheckIdempotency(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(object, newObject()) {
+		t.Errorf("unexpected change to the object: %v", object)
+	}
+	expected := []*NonIdempotentExpression{
+		{
+			Mutation:   0,
+			Index:      0,
+			Expression: `object.spec.merge({"args": ["a"]})`,
+			Patch:      mutator.JSONPatch{{Op: mutator.PatchOpReplace, Path: "/spec/args", Value: []any{"a"}}},
+		},
+		{
+			Mutation:   0,
+			Index:      1,
+			Expression: `object.spec.args.merge(["b"])`,
+			Patch:      mutator.JSONPatch{{Op: mutator.PatchOpAdd, Path: "/spec/args/1", Value: "b"}},
+		},
+		{
+			Mutation:   1,
+			Index:      0,
+			Expression: `object.spec.merge({"replicas": object.spec.replicas + 1})`,
+			Patch:      mutator.JSONPatch{{Op: mutator.PatchOpReplace, Path: "/spec/replicas", Value: int64(3)}},
+		},
+	}
+	if !reflect.DeepEqual(nonIdempotent, expected) {
+		t.Errorf("expected %v but got %v", expected, nonIdempotent)
+	}
+
+	// removing a field fails once the field has been removed.
+	e, err = NewPolicyEvaluator(newPolicy(nil, api.Mutation{
+		Expressions: []string{`object.spec.replicas.remove()`},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonIdempotent, err = e.CheckIdempotency(newObject())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nonIdempotent) != 1 || nonIdempotent[0].Error == nil ||
+		!strings.Contains(nonIdempotent[0].String(), "fails on reinvocation: spec.mutation[0].expressions[0]: no such key") {
+		t.Errorf("expected the removal to fail on reinvocation, but got %v", nonIdempotent)
+	}
+}
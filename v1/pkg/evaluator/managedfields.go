@@ -0,0 +1,70 @@
+package evaluator
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+	"sigs.k8s.io/structured-merge-diff/v4/merge"
+	"sigs.k8s.io/structured-merge-diff/v4/typed"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/apply"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/mutator"
+)
+
+// fieldManagerPrefix qualifies the names of policies as field managers.
+const fieldManagerPrefix = "policy/"
+
+// metadataMutator is implemented by root mutators.
+type metadataMutator interface {
+	Ensure(path ...string) ref.Val
+}
+
+// FieldManager returns the name of the field manager that the policy acts
+// as when it applies changes.
+func (e *PolicyEvaluator) FieldManager() string {
+	return fieldManagerPrefix + e.policy.Name
+}
+
+// typeOf returns the structured-merge-diff type of the objects.
+func (e *PolicyEvaluator) typeOf() (*typed.ParseableType, error) {
+	if e.tracker == nil {
+		return apply.TypeOf(nil)
+	}
+	return e.tracker.Type()
+}
+
+// manageFields records in metadata.managedFields that the policy has applied
+// the fields, and returns the applied fields that have been changed while
+// owned by other managers.
+func (e *PolicyEvaluator) manageFields(root mutator.Interface, original, object map[string]any, applied *fieldpath.Set) (merge.Conflicts, error) {
+	t, err := e.typeOf()
+	if err != nil {
+		return nil, err
+	}
+	changed, err := apply.ChangedFields(t, original, object)
+	if err != nil {
+		return nil, err
+	}
+	managed, err := apply.ManagedFieldsOf(object)
+	if err != nil {
+		return nil, err
+	}
+	conflicts := managed.Conflicts(e.FieldManager(), applied, changed)
+	apiVersion, _, _ := unstructured.NestedString(object, "apiVersion")
+	managed.Apply(e.FieldManager(), apiVersion, applied, conflicts)
+	entries, err := managed.Unstructured()
+	if err != nil {
+		return nil, err
+	}
+	metadata, ok := root.(metadataMutator).Ensure("metadata").(mutator.FieldSetter)
+	if !ok {
+		return nil, fmt.Errorf("metadata: %w", mutator.ErrNotObject)
+	}
+	if result := metadata.SetField("managedFields", types.DefaultTypeAdapter.NativeToValue(entries)); types.IsError(result) {
+		return nil, result.(*types.Err)
+	}
+	return conflicts, nil
+}
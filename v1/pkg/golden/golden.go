@@ -0,0 +1,373 @@
+// Package golden runs golden-file tests of MutatingAdmissionPolicies.
+//
+// A test case is a directory with an expected.yaml file. The other YAML and
+// JSON files in the directory are either policies, if all their documents
+// are MutatingAdmissionPolicies, CustomResourceDefinitions, if all their
+// documents are, or input manifests. A file ending with .schema.json holds
+// the OpenAPI schema of the inputs, except for custom resources, which have
+// the schemas of their definitions. The policies are applied to the inputs,
+// and the output must match expected.yaml.
+package golden
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/admission"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/api"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/manifest"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/openapi"
+)
+
+// ExpectedFileName is the name of the file that marks a directory as a test
+// case and holds its expected output.
+const ExpectedFileName = "expected.yaml"
+
+const schemaFileSuffix = ".schema.json"
+
+// Case is a discovered test case.
+type Case struct {
+	// Name is the path of the directory relative to the discovery root.
+	Name string
+
+	Dir      string
+	Policies []string
+	Inputs   []string
+	Schema   string
+	CRDs     []string
+}
+
+// Result is the outcome of running a test case.
+type Result struct {
+	Case *Case
+
+	// Diff is the unified diff from the expected to the actual output,
+	// empty if they match.
+	Diff string
+
+	// Updated is true if the expected output has been rewritten.
+	Updated bool
+
+	// NonIdempotent describes the expressions that change the inputs again
+	// when their policies are reinvoked, if idempotency is checked.
+	NonIdempotent []string
+}
+
+// Passed returns true if the output matches the expected one, and no policy
+// has been found not to be idempotent.
+func (r *Result) Passed() bool {
+	return r.Diff == "" && len(r.NonIdempotent) == 0
+}
+
+// Discover finds all test cases under root, in lexical order.
+func Discover(root string) ([]*Case, error) {
+	var cases []*Case
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != ExpectedFileName {
+			return nil
+		}
+		dir := filepath.Dir(path)
+		name, err := filepath.Rel(root, dir)
+		if err != nil {
+			return err
+		}
+		c, err := newCase(name, dir)
+		if err != nil {
+			return fmt.Errorf("%s: %w", dir, err)
+		}
+		cases = append(cases, c)
+		return nil
+	})
+	return cases, err
+}
+
+func newCase(name, dir string) (*Case, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	c := &Case{Name: name, Dir: dir}
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name() == ExpectedFileName {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if strings.HasSuffix(entry.Name(), schemaFileSuffix) {
+			c.Schema = path
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+		kind, err := kindOfFile(path)
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case policyKind:
+			c.Policies = append(c.Policies, path)
+		case crdKind:
+			c.CRDs = append(c.CRDs, path)
+		default:
+			c.Inputs = append(c.Inputs, path)
+		}
+	}
+	if len(c.Policies) == 0 {
+		return nil, errors.New("no policy found")
+	}
+	if len(c.Inputs) == 0 {
+		return nil, errors.New("no input found")
+	}
+	return c, nil
+}
+
+var policyKind = api.SchemeGroupVersion.WithKind("MutatingAdmissionPolicy")
+var crdKind = schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"}
+
+// kindOfFile returns policyKind if all documents of the file are policies,
+// crdKind if all are CustomResourceDefinitions, or an empty kind for inputs.
+func kindOfFile(path string) (schema.GroupVersionKind, error) {
+	objects, err := readManifests(path)
+	if err != nil {
+		return schema.GroupVersionKind{}, err
+	}
+	policies, crds := 0, 0
+	for _, object := range objects {
+		switch object.GroupVersionKind() {
+		case policyKind:
+			policies++
+		case crdKind:
+			crds++
+		}
+	}
+	switch {
+	case policies > 0 && policies < len(objects):
+		return schema.GroupVersionKind{}, fmt.Errorf("%s: policies and manifests must be in separate files", path)
+	case crds > 0 && crds < len(objects):
+		return schema.GroupVersionKind{}, fmt.Errorf("%s: CustomResourceDefinitions and manifests must be in separate files", path)
+	case policies > 0:
+		return policyKind, nil
+	case crds > 0:
+		return crdKind, nil
+	}
+	return schema.GroupVersionKind{}, nil
+}
+
+// Runner runs test cases.
+type Runner struct {
+	schema    *spec.Schema
+	schemaOf  admission.SchemaFunc
+	operation api.OperationType
+	update    bool
+
+	checkIdempotency bool
+}
+
+// Option configures a Runner.
+type Option func(*Runner)
+
+// WithSchema sets the schema of the inputs for cases without their own.
+func WithSchema(schema *spec.Schema) Option {
+	return func(r *Runner) {
+		r.schema = schema
+	}
+}
+
+// WithSchemas sets the function that finds the schema of the inputs by
+// kind, for cases without their own schema. It takes precedence over
+// WithSchema for the kinds that it has a schema of.
+func WithSchemas(schemaOf admission.SchemaFunc) Option {
+	return func(r *Runner) {
+		r.schemaOf = schemaOf
+	}
+}
+
+// WithOperation sets the operation of the admission requests that the
+// policies are matched against. Defaults to CREATE.
+func WithOperation(operation api.OperationType) Option {
+	return func(r *Runner) {
+		r.operation = operation
+	}
+}
+
+// WithUpdate makes the runner rewrite the expected output of the cases that
+// do not match, instead of reporting them as failed.
+func WithUpdate(update bool) Option {
+	return func(r *Runner) {
+		r.update = update
+	}
+}
+
+// WithIdempotencyCheck makes the runner also check that the policies do not
+// change the inputs again when they are reinvoked, and fail the cases of
+// which some policies do. See admission.Policies.CheckIdempotency.
+func WithIdempotencyCheck(check bool) Option {
+	return func(r *Runner) {
+		r.checkIdempotency = check
+	}
+}
+
+// NewRunner creates a runner.
+func NewRunner(opts ...Option) *Runner {
+	r := &Runner{operation: api.Create}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Run runs the test case. The error is for a case that cannot run, e.g.
+// because a policy does not compile or fails.
+func (r *Runner) Run(c *Case) (*Result, error) {
+	schemaOf, err := r.schemas(c)
+	if err != nil {
+		return nil, err
+	}
+	var loaded []*api.MutatingAdmissionPolicy
+	for _, path := range c.Policies {
+		p, err := api.LoadPolicyFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, p...)
+	}
+	// policies are compiled once for each kind, against its schema.
+	kinds, err := admission.NewKindPolicies(loaded, schemaOf)
+	if err != nil {
+		return nil, err
+	}
+	var objects []*unstructured.Unstructured
+	for _, path := range c.Inputs {
+		inputs, err := readManifests(path)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, inputs...)
+	}
+	result := &Result{Case: c}
+	for _, object := range objects {
+		policies, err := kinds.For(object.GroupVersionKind())
+		if err != nil {
+			return nil, err
+		}
+		attr := manifest.AttributesOf(object, r.operation)
+		if r.checkIdempotency {
+			nonIdempotent, err := policies.CheckIdempotency(object.Object, attr)
+			if err != nil {
+				return nil, fmt.Errorf("%s %q: %w", object.GetKind(), object.GetName(), err)
+			}
+			for _, p := range nonIdempotent {
+				for _, e := range p.Expressions {
+					result.NonIdempotent = append(result.NonIdempotent,
+						fmt.Sprintf("%s %q: policy %q: %s", object.GetKind(), object.GetName(), p.Name, e))
+				}
+			}
+		}
+		admitted, err := policies.Admit(object.Object, attr)
+		if err != nil {
+			return nil, fmt.Errorf("%s %q: %w", object.GetKind(), object.GetName(), err)
+		}
+		object.Object = admitted.Object
+	}
+	actual := new(bytes.Buffer)
+	if err := manifest.WriteYAML(actual, objects); err != nil {
+		return nil, err
+	}
+
+	expectedPath := filepath.Join(c.Dir, ExpectedFileName)
+	expectedObjects, err := readManifests(expectedPath)
+	if err != nil {
+		return nil, err
+	}
+	// normalize the expected output, so that only the content counts.
+	expected := new(bytes.Buffer)
+	if err := manifest.WriteYAML(expected, expectedObjects); err != nil {
+		return nil, err
+	}
+	if expected.String() == actual.String() {
+		return result, nil
+	}
+	if r.update {
+		if err := os.WriteFile(expectedPath, actual.Bytes(), 0o644); err != nil {
+			return nil, err
+		}
+		result.Updated = true
+		return result, nil
+	}
+	result.Diff, err = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(expected.String()),
+		B:        difflib.SplitLines(actual.String()),
+		FromFile: expectedPath,
+		ToFile:   "actual",
+		Context:  3,
+	})
+	return result, err
+}
+
+// schemas returns the function that finds the schema of the inputs of the
+// kind, which is nil if there is none.
+func (r *Runner) schemas(c *Case) (admission.SchemaFunc, error) {
+	schemaOf := func(gvk schema.GroupVersionKind) (*spec.Schema, error) {
+		if r.schemaOf != nil {
+			if s, err := r.schemaOf(gvk); s != nil || err != nil {
+				return s, err
+			}
+		}
+		return r.schema, nil
+	}
+	if c.Schema != "" {
+		f, err := os.Open(c.Schema)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		s, err := openapi.LoadSchema(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.Schema, err)
+		}
+		schemaOf = func(schema.GroupVersionKind) (*spec.Schema, error) { return s, nil }
+	}
+	if len(c.CRDs) == 0 {
+		return schemaOf, nil
+	}
+	document, err := openapi.LoadDocumentFiles(c.CRDs...)
+	if err != nil {
+		return nil, err
+	}
+	return func(gvk schema.GroupVersionKind) (*spec.Schema, error) {
+		// the schemas of CustomResourceDefinitions have no references to
+		// resolve.
+		if name, ok := document.SchemaName(gvk); ok {
+			return document.Schemas[name], nil
+		}
+		return schemaOf(gvk)
+	}, nil
+}
+
+func readManifests(path string) ([]*unstructured.Unstructured, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	objects, err := manifest.Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return objects, nil
+}
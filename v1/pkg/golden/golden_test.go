@@ -0,0 +1,165 @@
+package golden
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/openapi"
+)
+
+const policy = `apiVersion: admissionregistration.k8s.io/v1alpha1
+kind: MutatingAdmissionPolicy
+metadata:
+  name: replicas
+spec:
+  matchConstraints:
+    resourceRules:
+    - apiGroups:   ["apps"]
+      apiVersions: ["v1"]
+      operations:  ["CREATE"]
+      resources:   ["deployments"]
+  mutation:
+  - expressions:
+    - 'object.spec.merge({"replicas": 3})'
+`
+
+const input = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+spec:
+  replicas: 1
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nginx
+`
+
+func writeCase(t *testing.T, dir, expected string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"policy.yaml":    policy,
+		"input.yaml":     input,
+		ExpectedFileName: expected,
+		"README.md":      "not a manifest",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	root := t.TempDir()
+	writeCase(t, filepath.Join(root, "pass"), `apiVersion: apps/v1
+kind: Deployment
+metadata: {name: nginx}
+spec: {replicas: 3}
+---
+{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "nginx"}}
+`)
+	writeCase(t, filepath.Join(root, "nested", "fail"), input)
+
+	cases, err := Discover(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) != 2 || cases[0].Name != filepath.Join("nested", "fail") || cases[1].Name != "pass" {
+		t.Fatalf("unexpected cases: %v", cases)
+	}
+	for _, c := range cases {
+		if len(c.Policies) != 1 || filepath.Base(c.Policies[0]) != "policy.yaml" ||
+			len(c.Inputs) != 1 || filepath.Base(c.Inputs[0]) != "input.yaml" {
+			t.Errorf("unexpected files of %q: %v, %v", c.Name, c.Policies, c.Inputs)
+		}
+	}
+
+	result, err := NewRunner().Run(cases[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Passed() {
+		t.Errorf("expected %q to pass but got diff\n%s", cases[1].Name, result.Diff)
+	}
+
+	result, err = NewRunner().Run(cases[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Passed() {
+		t.Fatalf("expected %q to fail", cases[0].Name)
+	}
+	if !strings.Contains(result.Diff, "-  replicas: 1\n+  replicas: 3\n") {
+		t.Errorf("unexpected diff:\n%s", result.Diff)
+	}
+
+	result, err = NewRunner(WithUpdate(true)).Run(cases[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Updated {
+		t.Errorf("expected %q to be updated", cases[0].Name)
+	}
+	result, err = NewRunner().Run(cases[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Passed() {
+		t.Errorf("expected %q to pass after update but got diff\n%s", cases[0].Name, result.Diff)
+	}
+}
+
+func TestRunIdempotencyCheck(t *testing.T) {
+	cases, err := Discover("../../testdata/listmerge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) != 1 {
+		t.Fatalf("unexpected cases: %v", cases)
+	}
+	result, err := NewRunner().Run(cases[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Passed() {
+		t.Fatalf("expected %q to pass but got diff\n%s", cases[0].Name, result.Diff)
+	}
+	// the sidecar is appended again on reinvocation, because the list has
+	// no keys without a schema.
+	result, err = NewRunner(WithIdempotencyCheck(true)).Run(cases[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Passed() || result.Diff != "" || len(result.NonIdempotent) != 1 {
+		t.Fatalf("expected %q to fail for idempotency only, but got %v\n%s", cases[0].Name, result.NonIdempotent, result.Diff)
+	}
+	if !strings.Contains(result.NonIdempotent[0], "spec.mutation[0].expressions[0]") {
+		t.Errorf("unexpected report: %s", result.NonIdempotent[0])
+	}
+}
+
+func TestRunWithSchemas(t *testing.T) {
+	cases, err := Discover("../../testdata/listmerge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	document, err := openapi.LoadDocumentFiles("../../testdata/openapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// containers are keyed by their names in the schema of Deployments, so
+	// that the sidecar is not appended again on reinvocation.
+	result, err := NewRunner(WithSchemas(document.SchemaOf), WithIdempotencyCheck(true)).Run(cases[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Passed() {
+		t.Errorf("expected %q to pass but got %v\n%s", cases[0].Name, result.NonIdempotent, result.Diff)
+	}
+}
@@ -0,0 +1,79 @@
+// Package manifest reads and writes streams of Kubernetes manifests, and
+// describes them as admission requests for offline evaluation of policies.
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	sigsyaml "sigs.k8s.io/yaml"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/api"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/matcher"
+)
+
+// Read decodes all objects from a stream of YAML documents or JSON objects.
+// Empty documents are skipped. Lists are not expanded.
+func Read(reader io.Reader) ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+	d := yaml.NewYAMLOrJSONDecoder(reader, 4096)
+	for {
+		object := make(map[string]any)
+		err := d.Decode(&object)
+		if errors.Is(err, io.EOF) {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode manifest: %w", err)
+		}
+		if len(object) == 0 {
+			continue
+		}
+		u := &unstructured.Unstructured{Object: object}
+		if u.GetKind() == "" || u.GetAPIVersion() == "" {
+			return nil, fmt.Errorf("manifest %d: missing apiVersion or kind", len(objects))
+		}
+		objects = append(objects, u)
+	}
+}
+
+// WriteYAML writes the objects as a stream of YAML documents.
+func WriteYAML(w io.Writer, objects []*unstructured.Unstructured) error {
+	for i, object := range objects {
+		b, err := sigsyaml.Marshal(object.Object)
+		if err != nil {
+			return fmt.Errorf("cannot encode %s %q: %w", object.GetKind(), object.GetName(), err)
+		}
+		if i > 0 {
+			if _, err := io.WriteString(w, "---\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AttributesOf describes the object as if it is in an admission request of
+// the given operation. Without discovery, the resource is guessed from the
+// kind, which holds for all built-in types and most custom resources.
+func AttributesOf(object *unstructured.Unstructured, operation api.OperationType) *matcher.Attributes {
+	resource, _ := meta.UnsafeGuessKindToResource(object.GroupVersionKind())
+	attr := &matcher.Attributes{
+		Resource:     resource,
+		Operation:    operation,
+		Name:         object.GetName(),
+		Namespace:    object.GetNamespace(),
+		ObjectLabels: object.GetLabels(),
+	}
+	if resource.Group == "" && resource.Resource == "namespaces" {
+		attr.NamespaceLabels = object.GetLabels()
+	}
+	return attr
+}
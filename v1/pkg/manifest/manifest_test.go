@@ -0,0 +1,69 @@
+package manifest
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/api"
+)
+
+func TestReadWrite(t *testing.T) {
+	objects, err := Read(strings.NewReader(`
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: prod
+  labels:
+    env: prod
+---
+---
+{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "nginx", "namespace": "prod"}}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("expected 2 objects but got %d", len(objects))
+	}
+
+	attr := AttributesOf(objects[0], api.Create)
+	if attr.Resource != (schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}) {
+		t.Errorf("unexpected resource: %v", attr.Resource)
+	}
+	if attr.NamespaceLabels["env"] != "prod" {
+		t.Errorf("expected labels of a namespace to be its namespace labels, got %v", attr.NamespaceLabels)
+	}
+	attr = AttributesOf(objects[1], api.Update)
+	if attr.Resource != (schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}) ||
+		attr.Name != "nginx" || attr.Namespace != "prod" || attr.Operation != api.Update {
+		t.Errorf("unexpected attributes: %+v", attr)
+	}
+
+	out := new(bytes.Buffer)
+	if err := WriteYAML(out, objects); err != nil {
+		t.Fatal(err)
+	}
+	expected := `apiVersion: v1
+kind: Namespace
+metadata:
+  labels:
+    env: prod
+  name: prod
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  namespace: prod
+`
+	if out.String() != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, out.String())
+	}
+
+	if _, err := Read(strings.NewReader("metadata:\n  name: nginx\n")); err == nil {
+		t.Errorf("expected error for a manifest without kind")
+	}
+}
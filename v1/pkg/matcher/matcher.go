@@ -0,0 +1,206 @@
+// Package matcher decides whether a MutatingAdmissionPolicy applies to an
+// admission request, following the semantics of matchConstraints of
+// ValidatingAdmissionPolicy.
+package matcher
+
+import (
+	"fmt"
+	"strings"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/api"
+)
+
+// Attributes describe an admission request.
+type Attributes struct {
+	Resource    schema.GroupVersionResource
+	SubResource string
+	Operation   api.OperationType
+
+	Name      string
+	Namespace string
+
+	// NamespaceLabels are the labels of the namespace of the object. For a
+	// request on a Namespace, these are the labels of the Namespace itself.
+	NamespaceLabels map[string]string
+
+	// ObjectLabels and OldObjectLabels are the labels of the object before
+	// and after the request. Either can be nil, e.g. OldObjectLabels for
+	// a CREATE request.
+	ObjectLabels    map[string]string
+	OldObjectLabels map[string]string
+}
+
+// Matcher matches requests against match constraints.
+type Matcher struct {
+	equivalents runtime.EquivalentResourceMapper
+}
+
+// NewMatcher creates a matcher. The mapper finds equivalent resources for
+// matchPolicy: Equivalent, and can be nil if there is none.
+func NewMatcher(equivalents runtime.EquivalentResourceMapper) *Matcher {
+	return &Matcher{equivalents: equivalents}
+}
+
+// Matches decides whether the request matches the constraints. If so, it
+// also returns the resource by which the request is matched, which differs
+// from the requested one if the request is only matched through an
+// equivalent resource.
+func (m *Matcher) Matches(constraints *api.MatchResources, attr *Attributes) (bool, schema.GroupVersionResource, error) {
+	if constraints == nil {
+		return false, schema.GroupVersionResource{}, nil
+	}
+	matched, err := matchesNamespaceSelector(constraints.NamespaceSelector, attr)
+	if err != nil || !matched {
+		return false, schema.GroupVersionResource{}, err
+	}
+	matched, err = matchesObjectSelector(constraints.ObjectSelector, attr)
+	if err != nil || !matched {
+		return false, schema.GroupVersionResource{}, err
+	}
+	if matchesRules(constraints.ExcludeResourceRules, attr, attr.Resource) {
+		return false, schema.GroupVersionResource{}, nil
+	}
+	if matchesRules(constraints.ResourceRules, attr, attr.Resource) {
+		return true, attr.Resource, nil
+	}
+	if constraints.MatchPolicy == nil || *constraints.MatchPolicy != api.Equivalent || m.equivalents == nil {
+		return false, schema.GroupVersionResource{}, nil
+	}
+	for _, equivalent := range m.equivalents.EquivalentResourcesFor(attr.Resource, attr.SubResource) {
+		if equivalent == attr.Resource {
+			continue
+		}
+		if matchesRules(constraints.ExcludeResourceRules, attr, equivalent) {
+			return false, schema.GroupVersionResource{}, nil
+		}
+		if matchesRules(constraints.ResourceRules, attr, equivalent) {
+			return true, equivalent, nil
+		}
+	}
+	return false, schema.GroupVersionResource{}, nil
+}
+
+func matchesNamespaceSelector(selector *metav1.LabelSelector, attr *Attributes) (bool, error) {
+	// cluster-scoped objects other than namespaces are not subject to the
+	// namespace selector.
+	if attr.Namespace == "" && !(attr.Resource.Group == "" && attr.Resource.Resource == "namespaces") {
+		return true, nil
+	}
+	s, err := asSelector(selector, "namespaceSelector")
+	if err != nil {
+		return false, err
+	}
+	return s.Matches(labels.Set(attr.NamespaceLabels)), nil
+}
+
+// matchesObjectSelector matches if either the object or the old object
+// matches the selector.
+func matchesObjectSelector(selector *metav1.LabelSelector, attr *Attributes) (bool, error) {
+	s, err := asSelector(selector, "objectSelector")
+	if err != nil {
+		return false, err
+	}
+	return s.Matches(labels.Set(attr.ObjectLabels)) || s.Matches(labels.Set(attr.OldObjectLabels)), nil
+}
+
+// asSelector converts the label selector, which matches everything if nil.
+func asSelector(selector *metav1.LabelSelector, name string) (labels.Selector, error) {
+	if selector == nil {
+		return labels.Everything(), nil
+	}
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return s, nil
+}
+
+func matchesRules(rules []api.NamedRuleWithOperations, attr *Attributes, resource schema.GroupVersionResource) bool {
+	for _, rule := range rules {
+		if matchesRule(&rule, attr, resource) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesRule(rule *api.NamedRuleWithOperations, attr *Attributes, resource schema.GroupVersionResource) bool {
+	return matchesOperation(rule.Operations, attr.Operation) &&
+		matchesValue(rule.APIGroups, resource.Group) &&
+		matchesValue(rule.APIVersions, resource.Version) &&
+		matchesResource(rule.Resources, resource.Resource, attr.SubResource) &&
+		matchesScope(rule.Scope, attr) &&
+		matchesResourceName(rule.ResourceNames, attr.Name)
+}
+
+func matchesOperation(operations []api.OperationType, operation api.OperationType) bool {
+	for _, o := range operations {
+		if o == api.OperationAll || o == operation {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesValue(values []string, value string) bool {
+	for _, v := range values {
+		if v == "*" || v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesResource matches the resource and subresource against the rule
+// resources, where "*" matches all resources, "*/*" all resources and
+// subresources, "pods/*" all subresources of pods and "*/scale" the scale
+// subresource of all resources.
+func matchesResource(resources []string, resource, subResource string) bool {
+	for _, r := range resources {
+		if r == "*/*" {
+			return true
+		}
+		res, sub, _ := strings.Cut(r, "/")
+		if res != "*" && res != resource {
+			continue
+		}
+		if sub == "*" || sub == subResource {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesScope(scope *admissionregistrationv1.ScopeType, attr *Attributes) bool {
+	if scope == nil || *scope == admissionregistrationv1.AllScopes {
+		return true
+	}
+	// namespaces are cluster-scoped, even though they have their names
+	// as their namespaces in admission requests.
+	clusterScoped := attr.Namespace == "" || attr.Resource.Group == "" && attr.Resource.Resource == "namespaces"
+	switch *scope {
+	case admissionregistrationv1.ClusterScope:
+		return clusterScoped
+	case admissionregistrationv1.NamespacedScope:
+		return !clusterScoped
+	}
+	return false
+}
+
+func matchesResourceName(names []string, name string) bool {
+	if len(names) == 0 {
+		return true
+	}
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,283 @@
+package matcher
+
+import (
+	"testing"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/api"
+)
+
+var (
+	deployments        = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	v1beta1Deployments = schema.GroupVersionResource{Group: "apps", Version: "v1beta1", Resource: "deployments"}
+	namespaces         = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+	nodes              = schema.GroupVersionResource{Version: "v1", Resource: "nodes"}
+)
+
+func rule(groups, versions, resources []string, operations ...api.OperationType) api.NamedRuleWithOperations {
+	r := api.NamedRuleWithOperations{}
+	r.APIGroups = groups
+	r.APIVersions = versions
+	r.Resources = resources
+	r.Operations = operations
+	return r
+}
+
+func withScope(r api.NamedRuleWithOperations, scope admissionregistrationv1.ScopeType) api.NamedRuleWithOperations {
+	r.Scope = &scope
+	return r
+}
+
+func withNames(r api.NamedRuleWithOperations, names ...string) api.NamedRuleWithOperations {
+	r.ResourceNames = names
+	return r
+}
+
+func newConstraints(rules ...api.NamedRuleWithOperations) *api.MatchResources {
+	constraints := &api.MatchResources{ResourceRules: rules}
+	api.SetDefaults_MatchResources(constraints)
+	return constraints
+}
+
+func TestMatches(t *testing.T) {
+	appsDeployments := rule([]string{"apps"}, []string{"v1"}, []string{"deployments"}, admissionregistrationv1.Create, admissionregistrationv1.Update)
+	all := rule([]string{"*"}, []string{"*"}, []string{"*"}, admissionregistrationv1.OperationAll)
+	equivalent := api.Equivalent
+	exact := api.Exact
+
+	equivalents := runtime.NewEquivalentResourceRegistry()
+	equivalents.RegisterKindFor(deployments, "", schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"})
+	equivalents.RegisterKindFor(v1beta1Deployments, "", schema.GroupVersionKind{Group: "apps", Version: "v1beta1", Kind: "Deployment"})
+	equivalents.RegisterKindFor(deployments, "scale", schema.GroupVersionKind{Group: "autoscaling", Version: "v1", Kind: "Scale"})
+
+	for _, tc := range []struct {
+		name             string
+		constraints      *api.MatchResources
+		attr             Attributes
+		expected         bool
+		expectedResource schema.GroupVersionResource
+		expectedErr      bool
+	}{
+		{
+			name:        "nil constraints",
+			constraints: nil,
+			attr:        Attributes{Resource: deployments, Operation: admissionregistrationv1.Create},
+		},
+		{
+			name:             "exact",
+			constraints:      newConstraints(appsDeployments),
+			attr:             Attributes{Resource: deployments, Operation: admissionregistrationv1.Create, Namespace: "default"},
+			expected:         true,
+			expectedResource: deployments,
+		},
+		{
+			name:        "operation mismatch",
+			constraints: newConstraints(appsDeployments),
+			attr:        Attributes{Resource: deployments, Operation: admissionregistrationv1.Delete, Namespace: "default"},
+		},
+		{
+			name: "version mismatch",
+			constraints: func() *api.MatchResources {
+				c := newConstraints(appsDeployments)
+				c.MatchPolicy = &exact
+				return c
+			}(),
+			attr: Attributes{Resource: v1beta1Deployments, Operation: admissionregistrationv1.Create},
+		},
+		{
+			name:             "wildcards",
+			constraints:      newConstraints(all),
+			attr:             Attributes{Resource: nodes, Operation: admissionregistrationv1.Connect},
+			expected:         true,
+			expectedResource: nodes,
+		},
+		{
+			name:        "subresource not matched by resource",
+			constraints: newConstraints(appsDeployments),
+			attr:        Attributes{Resource: deployments, SubResource: "scale", Operation: admissionregistrationv1.Update},
+		},
+		{
+			name:        "subresource not matched by wildcard",
+			constraints: newConstraints(all),
+			attr:        Attributes{Resource: deployments, SubResource: "scale", Operation: admissionregistrationv1.Update},
+		},
+		{
+			name:             "subresource",
+			constraints:      newConstraints(rule([]string{"apps"}, []string{"v1"}, []string{"deployments/scale"}, admissionregistrationv1.Update)),
+			attr:             Attributes{Resource: deployments, SubResource: "scale", Operation: admissionregistrationv1.Update},
+			expected:         true,
+			expectedResource: deployments,
+		},
+		{
+			name:             "any subresource",
+			constraints:      newConstraints(rule([]string{"apps"}, []string{"v1"}, []string{"deployments/*"}, admissionregistrationv1.Update)),
+			attr:             Attributes{Resource: deployments, SubResource: "status", Operation: admissionregistrationv1.Update},
+			expected:         true,
+			expectedResource: deployments,
+		},
+		{
+			name:             "subresource of any resource",
+			constraints:      newConstraints(rule([]string{"*"}, []string{"*"}, []string{"*/scale"}, admissionregistrationv1.Update)),
+			attr:             Attributes{Resource: deployments, SubResource: "scale", Operation: admissionregistrationv1.Update},
+			expected:         true,
+			expectedResource: deployments,
+		},
+		{
+			name:             "all resources and subresources",
+			constraints:      newConstraints(rule([]string{"*"}, []string{"*"}, []string{"*/*"}, admissionregistrationv1.Update)),
+			attr:             Attributes{Resource: deployments, SubResource: "scale", Operation: admissionregistrationv1.Update},
+			expected:         true,
+			expectedResource: deployments,
+		},
+		{
+			name: "excluded",
+			constraints: func() *api.MatchResources {
+				c := newConstraints(all)
+				c.ExcludeResourceRules = []api.NamedRuleWithOperations{appsDeployments}
+				return c
+			}(),
+			attr: Attributes{Resource: deployments, Operation: admissionregistrationv1.Create},
+		},
+		{
+			name:             "resource names",
+			constraints:      newConstraints(withNames(appsDeployments, "nginx")),
+			attr:             Attributes{Resource: deployments, Operation: admissionregistrationv1.Create, Name: "nginx"},
+			expected:         true,
+			expectedResource: deployments,
+		},
+		{
+			name:        "resource names mismatch",
+			constraints: newConstraints(withNames(appsDeployments, "nginx")),
+			attr:        Attributes{Resource: deployments, Operation: admissionregistrationv1.Create, Name: "redis"},
+		},
+		{
+			name:        "namespaced scope",
+			constraints: newConstraints(withScope(all, admissionregistrationv1.NamespacedScope)),
+			attr:        Attributes{Resource: namespaces, Operation: admissionregistrationv1.Create, Name: "default", Namespace: "default"},
+		},
+		{
+			name:             "cluster scope",
+			constraints:      newConstraints(withScope(all, admissionregistrationv1.ClusterScope)),
+			attr:             Attributes{Resource: nodes, Operation: admissionregistrationv1.Create, Name: "node"},
+			expected:         true,
+			expectedResource: nodes,
+		},
+		{
+			name: "namespace selector",
+			constraints: func() *api.MatchResources {
+				c := newConstraints(all)
+				c.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}
+				return c
+			}(),
+			attr:             Attributes{Resource: deployments, Operation: admissionregistrationv1.Create, Namespace: "prod", NamespaceLabels: map[string]string{"env": "prod"}},
+			expected:         true,
+			expectedResource: deployments,
+		},
+		{
+			name: "namespace selector mismatch",
+			constraints: func() *api.MatchResources {
+				c := newConstraints(all)
+				c.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}
+				return c
+			}(),
+			attr: Attributes{Resource: deployments, Operation: admissionregistrationv1.Create, Namespace: "dev", NamespaceLabels: map[string]string{"env": "dev"}},
+		},
+		{
+			name: "namespace selector ignores cluster-scoped resources",
+			constraints: func() *api.MatchResources {
+				c := newConstraints(all)
+				c.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}
+				return c
+			}(),
+			attr:             Attributes{Resource: nodes, Operation: admissionregistrationv1.Create},
+			expected:         true,
+			expectedResource: nodes,
+		},
+		{
+			name: "namespace selector applies to namespaces",
+			constraints: func() *api.MatchResources {
+				c := newConstraints(all)
+				c.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}
+				return c
+			}(),
+			attr: Attributes{Resource: namespaces, Operation: admissionregistrationv1.Create, Name: "dev"},
+		},
+		{
+			name: "object selector matches old object",
+			constraints: func() *api.MatchResources {
+				c := newConstraints(all)
+				c.ObjectSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"nginx"}},
+				}}
+				return c
+			}(),
+			attr:             Attributes{Resource: deployments, Operation: admissionregistrationv1.Update, ObjectLabels: map[string]string{}, OldObjectLabels: map[string]string{"app": "nginx"}},
+			expected:         true,
+			expectedResource: deployments,
+		},
+		{
+			name: "object selector mismatch",
+			constraints: func() *api.MatchResources {
+				c := newConstraints(all)
+				c.ObjectSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "nginx"}}
+				return c
+			}(),
+			attr: Attributes{Resource: deployments, Operation: admissionregistrationv1.Create, ObjectLabels: map[string]string{"app": "redis"}},
+		},
+		{
+			name: "invalid selector",
+			constraints: func() *api.MatchResources {
+				c := newConstraints(all)
+				c.ObjectSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "app", Operator: "Bad"},
+				}}
+				return c
+			}(),
+			attr:        Attributes{Resource: deployments, Operation: admissionregistrationv1.Create},
+			expectedErr: true,
+		},
+		{
+			name:             "equivalent",
+			constraints:      newConstraints(appsDeployments),
+			attr:             Attributes{Resource: v1beta1Deployments, Operation: admissionregistrationv1.Create},
+			expected:         true,
+			expectedResource: deployments,
+		},
+		{
+			name: "exact policy ignores equivalents",
+			constraints: func() *api.MatchResources {
+				c := newConstraints(appsDeployments)
+				c.MatchPolicy = &exact
+				return c
+			}(),
+			attr: Attributes{Resource: v1beta1Deployments, Operation: admissionregistrationv1.Create},
+		},
+		{
+			name: "equivalent excluded",
+			constraints: func() *api.MatchResources {
+				c := newConstraints(appsDeployments)
+				c.MatchPolicy = &equivalent
+				c.ExcludeResourceRules = []api.NamedRuleWithOperations{withNames(appsDeployments, "nginx")}
+				return c
+			}(),
+			attr: Attributes{Resource: v1beta1Deployments, Operation: admissionregistrationv1.Create, Name: "nginx"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			matched, resource, err := NewMatcher(equivalents).Matches(tc.constraints, &tc.attr)
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if matched != tc.expected {
+				t.Errorf("expected %v but got %v", tc.expected, matched)
+			}
+			if resource != tc.expectedResource {
+				t.Errorf("expected resource %v but got %v", tc.expectedResource, resource)
+			}
+		})
+	}
+}
@@ -7,11 +7,17 @@ import (
 	"github.com/google/cel-go/cel"
 	"github.com/google/cel-go/common/types"
 	"github.com/google/cel-go/common/types/ref"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/apply"
 )
 
 type abstractMutator struct {
 	parent     Interface
 	identifier any
+
+	// tracker is at the schema of the value, or nil if the schema is unknown.
+	tracker *apply.SchemaTracker
 }
 
 var abstractMutatorTypeValue = cel.ObjectType("io.x-k8s.AbstractMutator")
@@ -44,10 +50,29 @@ func (a *abstractMutator) Identifier() any {
 	return a.identifier
 }
 
+func (a *abstractMutator) Schema() *spec.Schema {
+	if a.tracker == nil {
+		return nil
+	}
+	return a.tracker.Schema()
+}
+
+func (a *abstractMutator) schemaTracker() *apply.SchemaTracker {
+	return a.tracker
+}
+
 func (a *abstractMutator) Merge(patch any) ref.Val {
 	return types.NoSuchOverloadErr()
 }
 
+func (a *abstractMutator) Apply(patch any) ref.Val {
+	return types.NoSuchOverloadErr()
+}
+
+func (a *abstractMutator) Set(value ref.Val) ref.Val {
+	return types.NoSuchOverloadErr()
+}
+
 func (a *abstractMutator) Remove() ref.Val {
 	return types.NoSuchOverloadErr()
 }
@@ -0,0 +1,270 @@
+package mutator
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/apply"
+)
+
+var ErrApply = fmt.Errorf("cannot apply")
+
+// Apply merges the patch, a partial object, into the object with the
+// semantics of server-side apply: associative lists are merged by their
+// keys, sets by their values, and atomic lists and maps are replaced, as the
+// schema defines. Without a schema, objects are merged and lists replaced.
+func (o *objectMutator) Apply(patch any) ref.Val {
+	native, err := toNative(patch)
+	if err != nil {
+		return types.WrapErr(err)
+	}
+	p, ok := native.(map[string]any)
+	if !ok {
+		return types.NoSuchOverloadErr()
+	}
+	if err := validatePatch(o.tracker, pathOf(o), p); err != nil {
+		return types.WrapErr(err)
+	}
+	merged, err := structuredMerge(o.tracker, o.object, p)
+	if err != nil {
+		return types.WrapErr(fmt.Errorf("%w to %s: %v", ErrApply, pathOf(o), err))
+	}
+	syncObject(o.tracker, o.object, merged.(map[string]any), pointerOf(o), recorderOf(o))
+	return recordApplied(o, p)
+}
+
+// Apply merges the elements into the list with the semantics of server-side
+// apply. See objectMutator.Apply.
+func (l *listMutator) Apply(patch any) ref.Val {
+	native, err := toNative(patch)
+	if err != nil {
+		return types.WrapErr(err)
+	}
+	elements, ok := native.([]any)
+	if !ok {
+		return types.NoSuchOverloadErr()
+	}
+	if err := validateValue(l.tracker, pathOf(l), elements); err != nil {
+		return types.WrapErr(err)
+	}
+	merged, err := structuredMerge(l.tracker, l.list, elements)
+	if err != nil {
+		return types.WrapErr(fmt.Errorf("%w to %s: %v", ErrApply, pathOf(l), err))
+	}
+	if !reflect.DeepEqual(l.list, merged) {
+		l.list = syncList(l.tracker, l.list, merged.([]any), pointerOf(l), recorderOf(l))
+		if err := l.Parent().(Container).SetChild(l.Identifier(), l.list); err != nil {
+			return types.WrapErr(err)
+		}
+	}
+	return recordApplied(l, elements)
+}
+
+// structuredMerge merges rhs into lhs with structured-merge-diff, typed by
+// the schema at which the tracker is, or deduced from the values if the
+// schema is unknown.
+func structuredMerge(tracker *apply.SchemaTracker, lhs, rhs any) (any, error) {
+	t, err := typeOf(tracker)
+	if err != nil {
+		return nil, err
+	}
+	l, err := t.FromUnstructured(lhs)
+	if err != nil {
+		return nil, err
+	}
+	r, err := t.FromUnstructured(rhs)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := l.Merge(r)
+	if err != nil {
+		return nil, err
+	}
+	return merged.AsValue().Unstructured(), nil
+}
+
+// syncObject changes target in place to be equal to desired, recording the
+// changes under the given JSON pointer. Objects and lists are synced
+// recursively, and anything else is replaced.
+func syncObject(t *apply.SchemaTracker, target, desired map[string]any, pointer string, r *patchRecorder) {
+	for name := range target {
+		if _, ok := desired[name]; !ok {
+			r.record(PatchOpRemove, childPointer(pointer, name), nil)
+			delete(target, name)
+		}
+	}
+	for name, val := range desired {
+		existing, exists := target[name]
+		if exists && reflect.DeepEqual(existing, val) {
+			continue
+		}
+		switch e := existing.(type) {
+		case map[string]any:
+			if desiredObject, ok := val.(map[string]any); ok {
+				syncObject(advance(t, name), e, desiredObject, childPointer(pointer, name), r)
+				continue
+			}
+		case []any:
+			if desiredList, ok := val.([]any); ok {
+				target[name] = syncList(advance(t, name), e, desiredList, childPointer(pointer, name), r)
+				continue
+			}
+		}
+		target[name] = val
+		r.record(addOrReplace(exists), childPointer(pointer, name), val)
+	}
+}
+
+// syncList returns target changed to be equal to desired, recording the
+// changes of the elements under the given JSON pointer. The elements that
+// both lists share, in the same order, are kept: elements of associative
+// lists are identified by their keys and synced, and other elements by
+// their values. The other elements are removed or added. Lists that are
+// neither sets nor associative are atomic, and replaced as a whole.
+func syncList(t *apply.SchemaTracker, target, desired []any, pointer string, r *patchRecorder) []any {
+	s := schemaOf(t)
+	if t := listType(s); t != listTypeSet && t != listTypeMap {
+		r.record(PatchOpReplace, pointer, desired)
+		return desired
+	}
+	keys := listMapKeys(s)
+	same := func(lhs, rhs any) bool {
+		l, lok := lhs.(map[string]any)
+		m, rok := rhs.(map[string]any)
+		if len(keys) > 0 && lok && rok {
+			return keysEqual(keys, l, m)
+		}
+		return reflect.DeepEqual(lhs, rhs)
+	}
+	kept, matches := commonElements(target, desired, same)
+	for i := len(target) - 1; i >= 0; i-- {
+		if !kept[i] {
+			r.record(PatchOpRemove, childPointer(pointer, i), nil)
+			target = append(target[:i], target[i+1:]...)
+		}
+	}
+	for i, val := range desired {
+		if !matches[i] {
+			r.record(PatchOpAdd, childPointer(pointer, i), val)
+			target = append(target[:i], append([]any{val}, target[i:]...)...)
+			continue
+		}
+		if reflect.DeepEqual(target[i], val) {
+			continue
+		}
+		existing, isObject := target[i].(map[string]any)
+		desiredObject, ok := val.(map[string]any)
+		if isObject && ok {
+			syncObject(advance(t, i), existing, desiredObject, childPointer(pointer, i), r)
+			continue
+		}
+		target[i] = val
+		r.record(PatchOpReplace, childPointer(pointer, i), val)
+	}
+	return target
+}
+
+// commonElements finds the longest common subsequence of the lists under
+// the given equality, and reports which elements of each list are in it.
+func commonElements(lhs, rhs []any, same func(lhs, rhs any) bool) ([]bool, []bool) {
+	lengths := make([][]int, len(lhs)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(rhs)+1)
+	}
+	for i := len(lhs) - 1; i >= 0; i-- {
+		for j := len(rhs) - 1; j >= 0; j-- {
+			switch {
+			case same(lhs[i], rhs[j]):
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			case lengths[i+1][j] >= lengths[i][j+1]:
+				lengths[i][j] = lengths[i+1][j]
+			default:
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	inLHS, inRHS := make([]bool, len(lhs)), make([]bool, len(rhs))
+	for i, j := 0, 0; i < len(lhs) && j < len(rhs); {
+		switch {
+		case same(lhs[i], rhs[j]):
+			inLHS[i], inRHS[j] = true, true
+			i, j = i+1, j+1
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return inLHS, inRHS
+}
+
+// recordApplied records the fields that the patch applied to the mutator
+// sets, so that the fields can be tracked as managed by whoever applies.
+func recordApplied(m Interface, patch any) ref.Val {
+	r := recorderOf(m)
+	if r == nil {
+		return types.NullValue
+	}
+	set, err := appliedFields(m, patch)
+	if err != nil {
+		return types.WrapErr(fmt.Errorf("%w to %s: %v", ErrApply, pathOf(m), err))
+	}
+	if r.applied == nil {
+		r.applied = set
+	} else {
+		r.applied = r.applied.Union(set)
+	}
+	return types.NullValue
+}
+
+// appliedFields returns the fields, from the root, that the patch applied to
+// the mutator sets. The patch is wrapped up to the root, where elements of
+// associative lists are identified by their keys, and other lists are owned
+// as a whole.
+func appliedFields(m Interface, patch any) (*fieldpath.Set, error) {
+	partial := deepCopy(patch)
+	for ; m.Parent() != nil; m = m.Parent() {
+		parent := m.Parent()
+		switch identifier := m.Identifier().(type) {
+		case string:
+			partial = map[string]any{identifier: partial}
+		case int:
+			keys := listMapKeys(parent.Schema())
+			element, isObject := partial.(map[string]any)
+			if len(keys) == 0 || !isObject {
+				partial = deepCopy(parent.Value())
+				continue
+			}
+			actual, _ := parent.(Container).Child(identifier)
+			for _, key := range keys {
+				if v, ok := actual.(map[string]any)[key]; ok {
+					element[key] = v
+				}
+			}
+			partial = []any{element}
+		}
+	}
+	t, err := typeOf(trackerOf(m))
+	if err != nil {
+		return nil, err
+	}
+	v, err := t.FromUnstructured(partial)
+	if err != nil {
+		return nil, err
+	}
+	return v.ToFieldSet()
+}
+
+// AppliedFieldsOf returns the fields that have been applied through the
+// root mutator and all its descendants so far, or nil if none.
+func AppliedFieldsOf(root Interface) *fieldpath.Set {
+	o, ok := root.(*objectMutator)
+	if !ok || o.recorder == nil {
+		return nil
+	}
+	return o.recorder.applied
+}
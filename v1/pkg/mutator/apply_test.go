@@ -0,0 +1,172 @@
+package mutator
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/apply"
+)
+
+func TestApply(t *testing.T) {
+	containers := func(containers ...any) map[string]any {
+		return map[string]any{"template": map[string]any{"spec": map[string]any{"containers": containers}}}
+	}
+	for _, tc := range []struct {
+		name          string
+		withSchema    bool
+		apply         func(spec *objectMutator) ref.Val
+		expectedSpec  map[string]any
+		expectedPatch JSONPatch
+		expectedErr   error
+	}{
+		{
+			name:       "associative list merged by keys",
+			withSchema: true,
+			apply: func(spec *objectMutator) ref.Val {
+				return spec.Apply(toRefVal(containers(
+					map[string]any{"name": "nginx", "args": []any{"-v"}},
+					map[string]any{"name": "sidecar", "image": "busybox"},
+				)))
+			},
+			expectedSpec: map[string]any{"replicas": int64(1), "template": map[string]any{"spec": map[string]any{"containers": []any{
+				map[string]any{"name": "nginx", "image": "nginx", "args": []any{"-v"}},
+				map[string]any{"name": "sidecar", "image": "busybox"},
+			}}}},
+			expectedPatch: JSONPatch{
+				{Op: PatchOpAdd, Path: "/spec/template/spec/containers/0/args", Value: []any{"-v"}},
+				{Op: PatchOpAdd, Path: "/spec/template/spec/containers/1", Value: map[string]any{"name": "sidecar", "image": "busybox"}},
+			},
+		},
+		{
+			name:       "unchanged",
+			withSchema: true,
+			apply: func(spec *objectMutator) ref.Val {
+				return spec.Apply(toRefVal(map[string]any{"replicas": int64(1)}))
+			},
+			expectedSpec:  newDeployment()["spec"].(map[string]any),
+			expectedPatch: JSONPatch{},
+		},
+		{
+			name: "list replaced without schema",
+			apply: func(spec *objectMutator) ref.Val {
+				return spec.Apply(toRefVal(containers(map[string]any{"name": "sidecar"})))
+			},
+			expectedSpec: map[string]any{"replicas": int64(1), "template": map[string]any{"spec": map[string]any{"containers": []any{
+				map[string]any{"name": "sidecar"},
+			}}}},
+			expectedPatch: JSONPatch{{Op: PatchOpReplace, Path: "/spec/template/spec/containers", Value: []any{map[string]any{"name": "sidecar"}}}},
+		},
+		{
+			name:       "list mutator",
+			withSchema: true,
+			apply: func(spec *objectMutator) ref.Val {
+				list := spec.Get(types.String("template")).(*objectMutator).
+					Get(types.String("spec")).(*objectMutator).
+					Get(types.String("containers")).(Interface)
+				return list.Apply(toRefVal([]any{map[string]any{"name": "nginx", "image": "nginx:1.25"}}))
+			},
+			expectedSpec: map[string]any{"replicas": int64(1), "template": map[string]any{"spec": map[string]any{"containers": []any{
+				map[string]any{"name": "nginx", "image": "nginx:1.25"},
+			}}}},
+			expectedPatch: JSONPatch{{Op: PatchOpReplace, Path: "/spec/template/spec/containers/0/image", Value: "nginx:1.25"}},
+		},
+		{
+			name:       "unknown field",
+			withSchema: true,
+			apply: func(spec *objectMutator) ref.Val {
+				return spec.Apply(toRefVal(map[string]any{"replica": int64(3)}))
+			},
+			expectedErr: ErrUnknownField,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := newDeployment()
+			m := NewRootObjectMutator(root)
+			if tc.withSchema {
+				m = NewRootObjectMutatorWithSchema(root, loadDeploymentSchema(t))
+			}
+			spec := m.(*objectMutator).Get(types.String("spec")).(*objectMutator)
+			result := tc.apply(spec)
+			if tc.expectedErr != nil {
+				if !types.IsError(result) || !errors.Is(result.(*types.Err), tc.expectedErr) {
+					t.Fatalf("expected %v but got %v", tc.expectedErr, result)
+				}
+				if !reflect.DeepEqual(root, newDeployment()) {
+					t.Errorf("unexpected change: %v", root)
+				}
+				return
+			}
+			if types.IsError(result) {
+				t.Fatal(result)
+			}
+			if !reflect.DeepEqual(root["spec"], tc.expectedSpec) {
+				t.Errorf("expected %v but got %v", tc.expectedSpec, root["spec"])
+			}
+			if tc.expectedPatch != nil {
+				if patch := JSONPatchOf(m); !reflect.DeepEqual(patch, tc.expectedPatch) {
+					t.Errorf("expected patch %v but got %v", tc.expectedPatch, patch)
+				}
+			}
+		})
+	}
+}
+
+func TestSyncList(t *testing.T) {
+	nginx := map[string]any{"name": "nginx", "image": "nginx"}
+	sidecar := map[string]any{"name": "sidecar", "image": "busybox"}
+	for _, tc := range []struct {
+		name          string
+		extensions    spec.Extensions
+		target        []any
+		desired       []any
+		expectedPatch JSONPatch
+	}{
+		{
+			name:       "associative",
+			extensions: spec.Extensions{extListType: "map", extListMapKeys: []any{"name"}},
+			target:     []any{nginx, sidecar},
+			desired: []any{
+				map[string]any{"name": "init"},
+				map[string]any{"name": "nginx", "image": "nginx:1.25"},
+			},
+			expectedPatch: JSONPatch{
+				{Op: PatchOpRemove, Path: "/containers/1"},
+				{Op: PatchOpAdd, Path: "/containers/0", Value: map[string]any{"name": "init"}},
+				{Op: PatchOpReplace, Path: "/containers/1/image", Value: "nginx:1.25"},
+			},
+		},
+		{
+			name:       "set",
+			extensions: spec.Extensions{extListType: "set"},
+			target:     []any{"a", "c"},
+			desired:    []any{"a", "b", "c"},
+			expectedPatch: JSONPatch{
+				{Op: PatchOpAdd, Path: "/containers/1", Value: "b"},
+			},
+		},
+		{
+			name:          "atomic",
+			target:        []any{nginx},
+			desired:       []any{nginx, sidecar},
+			expectedPatch: JSONPatch{{Op: PatchOpReplace, Path: "/containers", Value: []any{nginx, sidecar}}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := containersSchema(tc.extensions).Properties["containers"]
+			r := new(patchRecorder)
+			target := deepCopy(tc.target).([]any)
+			synced := syncList(apply.NewSchemaTracker(&s, nil), target, deepCopy(tc.desired).([]any), "/containers", r)
+			if !reflect.DeepEqual(synced, tc.desired) {
+				t.Errorf("expected %v but got %v", tc.desired, synced)
+			}
+			if !reflect.DeepEqual(r.patch, tc.expectedPatch) {
+				t.Errorf("expected patch %v but got %v", tc.expectedPatch, r.patch)
+			}
+		})
+	}
+}
@@ -1,18 +1,16 @@
 package mutator
 
 import (
+	"reflect"
+
 	"github.com/google/cel-go/common/types"
 	"github.com/google/cel-go/common/types/ref"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/apply"
 )
 
 func mutatorOf(v any, parent Container, key any) ref.Val {
 	switch v.(type) {
-	case string:
-		return types.String(v.(string))
-	case int:
-		return types.Int(v.(int))
-	case int64:
-		return types.Int(v.(int64))
 	case map[string]any:
 		mutator, err := NewObjectMutator(parent, key)
 		if err != nil {
@@ -25,7 +23,45 @@ func mutatorOf(v any, parent Container, key any) ref.Val {
 			return types.WrapErr(err)
 		}
 		return mutator
+	case nil, bool, string, int, int32, int64, float32, float64:
+		// scalars are read as plain values, so that they work with all
+		// functions and operators. Parents set and remove them instead.
+		return types.DefaultTypeAdapter.NativeToValue(v)
 	default:
-		return types.NewErr("missing mutator for: %v", v)
+		return types.NewErr("missing mutator for %T", v)
+	}
+}
+
+// removeFromParent removes the value of the mutator from its parent. The root
+// mutator cannot be removed.
+func removeFromParent(m Interface) ref.Val {
+	if container, ok := m.Parent().(Container); ok && m.Identifier() != nil {
+		err := container.RemoveChild(m.Identifier())
+		if err != nil {
+			return types.WrapErr(err)
+		}
+		return types.NullValue
+	}
+	return types.NoSuchOverloadErr()
+}
+
+// setChild replaces the child of the container, or adds it if missing, after
+// validating the value against the schema of the child, at which the tracker
+// is.
+func setChild(container Container, identifier any, t *apply.SchemaTracker, value any) ref.Val {
+	if container == nil {
+		return types.NoSuchOverloadErr()
+	}
+	if err := validateValue(t, childPath(pathOf(container), identifier), value); err != nil {
+		return types.WrapErr(err)
+	}
+	existing, exists := container.Child(identifier)
+	if exists && reflect.DeepEqual(existing, value) {
+		return types.NullValue
+	}
+	if err := container.SetChild(identifier, value); err != nil {
+		return types.WrapErr(err)
 	}
+	recorderOf(container).record(addOrReplace(exists), childPointer(pointerOf(container), identifier), value)
+	return types.NullValue
 }
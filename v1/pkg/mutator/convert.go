@@ -0,0 +1,108 @@
+package mutator
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+)
+
+// ErrUnsupportedValue is returned for values that have no JSON counterpart.
+var ErrUnsupportedValue = fmt.Errorf("unsupported value")
+
+// toNative converts the argument of a mutator function to its JSON
+// counterpart. The argument is either a CEL value or the value of a CEL
+// list or map.
+func toNative(v any) (any, error) {
+	switch v := v.(type) {
+	case ref.Val:
+		return refToNative(v)
+	case map[ref.Val]ref.Val:
+		return refMapToNative(v)
+	case []ref.Val:
+		return refSliceToNative(v)
+	}
+	return nil, fmt.Errorf("%w: %T", ErrUnsupportedValue, v)
+}
+
+// refToNative converts the CEL value to its JSON counterpart, i.e. nil, bool,
+// int64, float64, string, []any or map[string]any. Bytes are encoded in
+// base64, and timestamps and durations as strings, the way Kubernetes
+// encodes them. A mutator converts to a copy of the value it refers to.
+func refToNative(v ref.Val) (any, error) {
+	switch v := v.(type) {
+	case types.Null:
+		return nil, nil
+	case types.Bool:
+		return bool(v), nil
+	case types.Int:
+		return int64(v), nil
+	case types.Uint:
+		if v > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: %d overflows int64", ErrUnsupportedValue, uint64(v))
+		}
+		return int64(v), nil
+	case types.Double:
+		return float64(v), nil
+	case types.String:
+		return string(v), nil
+	case types.Bytes:
+		return base64.StdEncoding.EncodeToString(v), nil
+	case types.Timestamp:
+		return v.Time.UTC().Format(time.RFC3339), nil
+	case types.Duration:
+		return v.Duration.String(), nil
+	case *objectMutator:
+		return deepCopy(v.object), nil
+	case *listMutator:
+		return deepCopy(v.list), nil
+	case *types.Err:
+		return nil, v
+	case traits.Lister:
+		ret := make([]any, 0, int(v.Size().(types.Int)))
+		for it := v.Iterator(); it.HasNext() == types.True; {
+			element, err := refToNative(it.Next())
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, element)
+		}
+		return ret, nil
+	case traits.Mapper:
+		ret := make(map[string]any, int(v.Size().(types.Int)))
+		for it := v.Iterator(); it.HasNext() == types.True; {
+			key := it.Next()
+			name, ok := key.(types.String)
+			if !ok {
+				return nil, fmt.Errorf("%w: map key %v of type %s", ErrUnsupportedValue, key, key.Type().TypeName())
+			}
+			value, err := refToNative(v.Get(key))
+			if err != nil {
+				return nil, err
+			}
+			ret[string(name)] = value
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedValue, v.Type().TypeName())
+}
+
+func refMapToNative(refMap map[ref.Val]ref.Val) (map[string]any, error) {
+	native, err := refToNative(types.NewRefValMap(types.DefaultTypeAdapter, refMap))
+	if err != nil {
+		return nil, err
+	}
+	return native.(map[string]any), nil
+}
+
+func refSliceToNative(refSlice []ref.Val) ([]any, error) {
+	native, err := refToNative(types.NewRefValList(types.DefaultTypeAdapter, refSlice))
+	if err != nil {
+		return nil, err
+	}
+	return native.([]any), nil
+}
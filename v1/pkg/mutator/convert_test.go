@@ -0,0 +1,167 @@
+package mutator
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+func loadFixture(t *testing.T, fileName string) map[string]any {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("cannot load fixture: %v", err)
+	}
+	object := make(map[string]any)
+	if err := yaml.Unmarshal(b, &object); err != nil {
+		t.Fatalf("cannot parse fixture: %v", err)
+	}
+	return object
+}
+
+// walk visits all descendants of the container, setting every scalar to the
+// value read from it.
+func walk(t *testing.T, m Interface) {
+	set := func(child ref.Val, result ref.Val) {
+		if types.IsError(child) {
+			t.Fatalf("%s: %v", pathOf(m), child)
+		}
+		if types.IsError(result) {
+			t.Errorf("%s: %v", pathOf(m), result)
+		}
+	}
+	switch m := m.(type) {
+	case *objectMutator:
+		for name := range m.object {
+			child := m.Get(types.String(name))
+			if c, ok := child.(Interface); ok {
+				walk(t, c)
+				continue
+			}
+			set(child, m.SetField(name, child))
+		}
+	case *listMutator:
+		for i := range m.list {
+			child := m.Get(types.Int(i))
+			if c, ok := child.(Interface); ok {
+				walk(t, c)
+				continue
+			}
+			set(child, m.SetElement(i, child))
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, fileName := range []string{
+		"../../testdata/pod.yaml",
+		"../../testdata/listmerge/deploy.yaml",
+	} {
+		t.Run(fileName, func(t *testing.T) {
+			object := loadFixture(t, fileName)
+			expected := loadFixture(t, fileName)
+
+			native, err := refToNative(types.DefaultTypeAdapter.NativeToValue(object))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(native, expected) {
+				t.Errorf("expected %v but got %v", expected, native)
+			}
+
+			root := NewRootObjectMutator(object)
+			walk(t, root)
+			if patch := JSONPatchOf(root); len(patch) != 0 {
+				t.Errorf("unexpected patch: %v", patch)
+			}
+			if !reflect.DeepEqual(object, expected) {
+				t.Errorf("expected %v but got %v", expected, object)
+			}
+
+			native, err = refToNative(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(native, expected) {
+				t.Errorf("expected %v but got %v", expected, native)
+			}
+		})
+	}
+}
+
+func TestRefToNative(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		value       ref.Val
+		expected    any
+		expectedErr error
+	}{
+		{name: "null", value: types.NullValue, expected: nil},
+		{name: "bool", value: types.True, expected: true},
+		{name: "int", value: types.Int(-1), expected: int64(-1)},
+		{name: "uint", value: types.Uint(1), expected: int64(1)},
+		{name: "uint overflow", value: types.Uint(1 << 63), expectedErr: ErrUnsupportedValue},
+		{name: "double", value: types.Double(0.5), expected: 0.5},
+		{name: "string", value: types.String("a"), expected: "a"},
+		{name: "bytes", value: types.Bytes("a"), expected: "YQ=="},
+		{name: "timestamp", value: types.Timestamp{Time: time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)}, expected: "2023-09-01T00:00:00Z"},
+		{name: "duration", value: types.Duration{Duration: 90 * time.Second}, expected: "1m30s"},
+		{
+			name:     "nested",
+			value:    toRefVal(map[string]any{"a": []any{nil, int64(1), 0.5, map[string]any{"b": false}}}),
+			expected: map[string]any{"a": []any{nil, int64(1), 0.5, map[string]any{"b": false}}},
+		},
+		{
+			name:        "non-string key",
+			value:       types.NewRefValMap(types.DefaultTypeAdapter, map[ref.Val]ref.Val{types.Int(1): types.True}),
+			expectedErr: ErrUnsupportedValue,
+		},
+		{name: "type", value: types.IntType, expectedErr: ErrUnsupportedValue},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			native, err := refToNative(tc.value)
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected %v but got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(native, tc.expected) {
+				t.Errorf("expected %v but got %v", tc.expected, native)
+			}
+		})
+	}
+}
+
+func TestMutatorOf(t *testing.T) {
+	root := map[string]any{
+		"int":    1,
+		"int32":  int32(1),
+		"float":  float32(0.5),
+		"null":   nil,
+		"object": map[string]any{},
+		"list":   []any{},
+		"other":  struct{}{},
+	}
+	m := NewRootObjectMutator(root).(*objectMutator)
+	for name := range root {
+		v := m.Get(types.String(name))
+		if name == "other" {
+			if !types.IsError(v) {
+				t.Errorf("expected error for %q but got %v", name, v)
+			}
+			continue
+		}
+		if types.IsError(v) {
+			t.Errorf("unexpected error for %q: %v", name, v)
+		}
+	}
+}
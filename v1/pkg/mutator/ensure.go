@@ -0,0 +1,49 @@
+package mutator
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+// Ensure returns the mutator of the descendant at the given path of field
+// names, creating the missing fields along the path as empty objects. If the
+// schema describes a missing field as a list, an empty list is created
+// instead, which can only be the last element of the path.
+func (o *objectMutator) Ensure(path ...string) ref.Val {
+	var current Container = o
+	for i, name := range path {
+		object, ok := current.(*objectMutator)
+		if !ok {
+			return types.WrapErr(fmt.Errorf("%s: %w", pathOf(current), ErrNotObject))
+		}
+		child, exists := object.Child(name)
+		if !exists {
+			t, err := advanceField(object.tracker, childPath(pathOf(object), name), name)
+			if err != nil {
+				return types.WrapErr(err)
+			}
+			if t != nil && isType(t.Schema(), "array") {
+				child = []any{}
+			} else {
+				child = map[string]any{}
+			}
+			if err := object.SetChild(name, child); err != nil {
+				return types.WrapErr(err)
+			}
+			recorderOf(object).record(PatchOpAdd, childPointer(pointerOf(object), name), child)
+		}
+		switch child.(type) {
+		case map[string]any, []any:
+		default:
+			return types.WrapErr(fmt.Errorf("%s: %w", childPath(pathOf(object), name), ErrNotObject))
+		}
+		m := mutatorOf(child, object, name)
+		if i == len(path)-1 || types.IsError(m) {
+			return m
+		}
+		current = m.(Container)
+	}
+	return o
+}
@@ -0,0 +1,47 @@
+package mutator
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/cel-go/common/types"
+)
+
+func TestEnsure(t *testing.T) {
+	root := newDeployment()
+	m := NewRootObjectMutatorWithSchema(root, loadDeploymentSchema(t)).(*objectMutator)
+
+	volumes := m.Ensure("spec", "template", "spec", "volumes")
+	if types.IsError(volumes) {
+		t.Fatal(volumes)
+	}
+	if _, ok := volumes.(*listMutator); !ok {
+		t.Errorf("expected a list mutator but got %T", volumes)
+	}
+	strategy := m.Ensure("spec", "strategy", "rollingUpdate")
+	if types.IsError(strategy) {
+		t.Fatal(strategy)
+	}
+	spec := root["spec"].(map[string]any)
+	if !reflect.DeepEqual(spec["strategy"], map[string]any{"rollingUpdate": map[string]any{}}) {
+		t.Errorf("unexpected strategy: %v", spec["strategy"])
+	}
+	if !reflect.DeepEqual(spec["template"].(map[string]any)["spec"].(map[string]any)["volumes"], []any{}) {
+		t.Errorf("unexpected volumes: %v", spec["template"])
+	}
+
+	for _, tc := range []struct {
+		path        []string
+		expectedErr error
+	}{
+		{path: []string{"spec", "strategyy"}, expectedErr: ErrUnknownField},
+		{path: []string{"spec", "replicas", "value"}, expectedErr: ErrNotObject},
+		{path: []string{"spec", "template", "spec", "volumes", "name"}, expectedErr: ErrNotObject},
+	} {
+		result := m.Ensure(tc.path...)
+		if !types.IsError(result) || !errors.Is(result.(*types.Err).Unwrap(), tc.expectedErr) {
+			t.Errorf("%v: expected %v but got %v", tc.path, tc.expectedErr, result)
+		}
+	}
+}
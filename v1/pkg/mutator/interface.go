@@ -2,6 +2,7 @@ package mutator
 
 import (
 	"github.com/google/cel-go/common/types/ref"
+	"k8s.io/kube-openapi/pkg/validation/spec"
 )
 
 type Interface interface {
@@ -14,12 +15,25 @@ type Interface interface {
 	// or nil for the root mutator
 	Identifier() any
 
+	// Schema returns the schema of the value that the mutator holds,
+	// or nil if the schema is unknown.
+	Schema() *spec.Schema
+
 	// Merge performs a simple JSON merge from the list that the mutator holds
 	// with the given patch. Returns whether the list has been changed, or any
-	// error.
+	// error. The patch is a CEL value, or the value of a CEL list or map.
 	Merge(patch any) ref.Val
 
-	// Remove removes the referring list from its parent.
+	// Apply merges the patch into the value that the mutator holds with the
+	// semantics of server-side apply. Returns null, or an error. The patch is
+	// a CEL value, or the value of a CEL list or map.
+	Apply(patch any) ref.Val
+
+	// Set replaces the value that the mutator refers to with the given value.
+	// Returns null, or an error.
+	Set(value ref.Val) ref.Val
+
+	// Remove removes the referring value from its parent.
 	// Returns null, or an error.
 	Remove() ref.Val
 }
@@ -36,3 +50,10 @@ type Container interface {
 	// SetChild replaces the child by the identifier.
 	SetChild(identifier any, value any) error
 }
+
+// FieldSetter is implemented by object mutators.
+type FieldSetter interface {
+	// SetField sets the named field of the object to the given value,
+	// adding the field if missing. Returns null, or an error.
+	SetField(name string, value ref.Val) ref.Val
+}
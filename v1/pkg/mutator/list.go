@@ -2,6 +2,7 @@ package mutator
 
 import (
 	"fmt"
+	"reflect"
 
 	"github.com/google/cel-go/cel"
 	"github.com/google/cel-go/common/types"
@@ -9,7 +10,17 @@ import (
 	"github.com/google/cel-go/common/types/traits"
 )
 
-var ListMutatorType = cel.ObjectType("kubernetes.ListMutator", traits.IndexerType)
+// ListMutatorType is the type of list mutators. Unlike object mutators, list
+// mutators have the list type, because the list functions of the Kubernetes
+// library dispatch on it at runtime. Calling the mutator functions on lists
+// that are not read from variables is rejected at check time instead.
+var ListMutatorType = types.ListType
+
+// ListMutatorTypeOf returns the type of list mutators of which the elements
+// are of the given type.
+func ListMutatorTypeOf(elementType *cel.Type) *cel.Type {
+	return cel.ListType(elementType)
+}
 
 var ErrNotList = fmt.Errorf("not a list")
 var ErrListIndexOutOfBound = fmt.Errorf("index out of bound")
@@ -33,23 +44,25 @@ func NewListMutator(parent Container, key any) (Interface, error) {
 	mutator.parent = parent
 	mutator.list = list
 	mutator.identifier = key
+	mutator.tracker = advance(trackerOf(parent), key)
 	return mutator, nil
 }
 
 func (l *listMutator) RemoveChild(identifier any) error {
 	if i, ok := identifier.(int); ok {
-		if i > len(l.list) {
+		if i < 0 || i >= len(l.list) {
 			return ErrListIndexOutOfBound
 		}
 		l.list = append(l.list[0:i], l.list[i+1:len(l.list)]...)
-		return nil
+		recorderOf(l).record(PatchOpRemove, childPointer(pointerOf(l), i), nil)
+		return l.Parent().(Container).SetChild(l.Identifier(), l.list)
 	}
 	return fmt.Errorf("expect index to be an int, but got a %t", identifier)
 }
 
 func (l *listMutator) Child(identifier any) (any, bool) {
 	if i, ok := identifier.(int); ok {
-		if i > len(l.list) {
+		if i < 0 || i >= len(l.list) {
 			return nil, false
 		}
 		return l.list[i], true
@@ -57,41 +70,160 @@ func (l *listMutator) Child(identifier any) (any, bool) {
 	return nil, false
 }
 
+// Remove removes the list from its parent.
+func (l *listMutator) Remove() ref.Val {
+	return removeFromParent(l)
+}
+
+func (l *listMutator) Set(value ref.Val) ref.Val {
+	container, _ := l.Parent().(Container)
+	native, err := refToNative(value)
+	if err != nil {
+		return types.WrapErr(err)
+	}
+	result := setChild(container, l.Identifier(), l.tracker, native)
+	if list, ok := native.([]any); ok && !types.IsError(result) {
+		l.list = list
+	}
+	return result
+}
+
+// Elements returns the elements of the list as a read-only CEL list.
+func (l *listMutator) Elements() ref.Val {
+	return types.DefaultTypeAdapter.NativeToValue(l.list)
+}
+
+// RemoveElements removes the elements at the given indices, which must be
+// distinct and in ascending order.
+func (l *listMutator) RemoveElements(indices []int) ref.Val {
+	// removing from the back keeps the remaining indices valid, for both the
+	// list and the recorded patch.
+	for j := len(indices) - 1; j >= 0; j-- {
+		if err := l.RemoveChild(indices[j]); err != nil {
+			return types.WrapErr(err)
+		}
+	}
+	return types.NullValue
+}
+
 func (l *listMutator) Get(index ref.Val) ref.Val {
 	iv, ok := index.(types.Int)
 	if !ok {
-		return types.MaybeNoSuchOverloadErr(iv)
-	}
-	i := iv.Value().(int)
-	if i < len(l.list) {
-		v := l.list[i]
-		switch v.(type) {
-		case map[string]any:
-			return mutatorOf(v, l, i)
-		case []any:
-			return mutatorOf(v, l, i)
-		default:
-			return types.NewErr("missing mutator for %t", v)
-		}
+		return types.MaybeNoSuchOverloadErr(index)
+	}
+	i := int(iv)
+	if i >= 0 && i < len(l.list) {
+		return mutatorOf(l.list[i], l, i)
 	}
 	return types.NewErr("array index out of bound: %d", i)
 }
 
 func (l *listMutator) Merge(rhs any) ref.Val {
-	patch, ok := rhs.([]ref.Val)
+	native, err := toNative(rhs)
+	if err != nil {
+		return types.WrapErr(err)
+	}
+	elements, ok := native.([]any)
 	if !ok {
 		return types.NoSuchOverloadErr()
 	}
-	return l.mergeList(patch)
+	return l.mergeList(elements)
 }
 
+// readOnly returns the list as a read-only CEL list.
+func (l *listMutator) readOnly() traits.Lister {
+	return types.DefaultTypeAdapter.NativeToValue(l.list).(traits.Lister)
+}
+
+func (l *listMutator) ConvertToNative(typeDesc reflect.Type) (any, error) {
+	return l.readOnly().ConvertToNative(typeDesc)
+}
+
+func (l *listMutator) ConvertToType(typeValue ref.Type) ref.Val {
+	switch typeValue {
+	case ListMutatorType:
+		return l
+	case types.TypeType:
+		return ListMutatorType
+	}
+	return l.readOnly().ConvertToType(typeValue)
+}
+
+func (l *listMutator) Equal(other ref.Val) ref.Val {
+	return l.readOnly().Equal(other)
+}
+
+// Type is list, so that the list can be read wherever a list is expected.
 func (l *listMutator) Type() ref.Type {
 	return ListMutatorType
 }
 
+func (l *listMutator) Value() any {
+	return l.list
+}
+
+// Add returns the concatenation of the lists, which is read-only.
+func (l *listMutator) Add(other ref.Val) ref.Val {
+	return l.readOnly().Add(other)
+}
+
+func (l *listMutator) Contains(value ref.Val) ref.Val {
+	return l.readOnly().Contains(value)
+}
+
+// Iterator iterates over the elements the way Get returns them, so that the
+// elements that comprehensions select can be mutated.
+func (l *listMutator) Iterator() traits.Iterator {
+	return &listIterator{list: l}
+}
+
+func (l *listMutator) Size() ref.Val {
+	return types.Int(len(l.list))
+}
+
+var _ traits.Lister = (*listMutator)(nil)
+
+type listIterator struct {
+	list  *listMutator
+	index int
+}
+
+func (it *listIterator) ConvertToNative(typeDesc reflect.Type) (any, error) {
+	return nil, fmt.Errorf("type conversion on iterators not supported")
+}
+
+func (it *listIterator) ConvertToType(typeValue ref.Type) ref.Val {
+	return types.NewErr("no such overload")
+}
+
+func (it *listIterator) Equal(other ref.Val) ref.Val {
+	return types.NewErr("no such overload")
+}
+
+func (it *listIterator) Type() ref.Type {
+	return types.IteratorType
+}
+
+func (it *listIterator) Value() any {
+	return nil
+}
+
+func (it *listIterator) HasNext() ref.Val {
+	return types.Bool(it.index < len(it.list.list))
+}
+
+func (it *listIterator) Next() ref.Val {
+	if it.index >= len(it.list.list) {
+		return nil
+	}
+	v := it.list.Get(types.Int(it.index))
+	it.index++
+	return v
+}
+
 func (l *listMutator) SetChild(identifier any, value any) error {
 	if i, ok := identifier.(int); ok {
-		if i > len(l.list) {
+		if i < 0 || i >= len(l.list) {
 			return ErrListIndexOutOfBound
 		}
 		l.list[i] = value
@@ -99,18 +231,63 @@ func (l *listMutator) SetChild(identifier any, value any) error {
 	}
 	return fmt.Errorf("expect index to be an int, but got a %t", identifier)
 }
-func (l *listMutator) mergeList(rhs []ref.Val) ref.Val {
-	for _, vv := range rhs {
-		var v any
-		switch vv.Value().(type) {
-		case []ref.Val:
-			v = refSliceToNative(vv.Value().([]ref.Val))
-		case map[ref.Val]ref.Val:
-			v = refMapToNative(vv.Value().(map[ref.Val]ref.Val))
-		default:
-			v = vv.Value()
+
+// SetElement replaces the element at the index with the value.
+func (l *listMutator) SetElement(index int, value ref.Val) ref.Val {
+	if index < 0 || index >= len(l.list) {
+		return types.WrapErr(ErrListIndexOutOfBound)
+	}
+	native, err := refToNative(value)
+	if err != nil {
+		return types.WrapErr(err)
+	}
+	return setChild(l, index, advance(l.tracker, index), native)
+}
+
+// mergeList merges the elements into the list according to the list type:
+// an atomic list is replaced, a set gains the elements it does not yet
+// contain, and a map list upserts elements by their keys. A list without
+// a known type has the elements appended.
+func (l *listMutator) mergeList(elements []any) ref.Val {
+	path := pathOf(l)
+	for i, element := range elements {
+		if err := validateValue(advance(l.tracker, i), childPath(path, i), element); err != nil {
+			return types.WrapErr(err)
+		}
+	}
+	if listType(l.Schema()) == listTypeMap {
+		if err := validateMapElements(listMapKeys(l.Schema()), path, elements); err != nil {
+			return types.WrapErr(err)
+		}
+	}
+	pointer := pointerOf(l)
+	r := recorderOf(l)
+	switch listType(l.Schema()) {
+	case listTypeAtomic:
+		if !reflect.DeepEqual(l.list, elements) {
+			l.list = elements
+			r.record(PatchOpReplace, pointer, l.list)
+		}
+	case listTypeSet:
+		for _, element := range elements {
+			if !l.contains(element) {
+				l.append(element, pointer, r)
+			}
+		}
+	case listTypeMap:
+		keys := listMapKeys(l.Schema())
+		for _, element := range elements {
+			m := element.(map[string]any)
+			if i := l.indexOfKeys(keys, m); i >= 0 {
+				mergePatch(l.list[i].(map[string]any), m, childPointer(pointer, i), r)
+				continue
+			}
+			l.append(element, pointer, r)
+		}
+	default:
+		for _, element := range elements {
+			l.append(element, pointer, r)
 		}
-		l.list = append(l.list, v)
 	}
 	err := l.Parent().(Container).SetChild(l.Identifier(), l.list)
 	if err != nil {
@@ -118,3 +295,45 @@ func (l *listMutator) mergeList(rhs []ref.Val) ref.Val {
 	}
 	return types.NullValue
 }
+
+func (l *listMutator) append(element any, pointer string, r *patchRecorder) {
+	r.record(PatchOpAdd, childPointer(pointer, len(l.list)), element)
+	l.list = append(l.list, element)
+}
+
+func (l *listMutator) contains(element any) bool {
+	for _, e := range l.list {
+		if reflect.DeepEqual(e, element) {
+			return true
+		}
+	}
+	return false
+}
+
+// indexOfKeys finds the element that has the same values of the given
+// key fields as the given element, or returns -1 if there is none.
+func (l *listMutator) indexOfKeys(keys []string, element map[string]any) int {
+	for i, e := range l.list {
+		existing, ok := e.(map[string]any)
+		if !ok {
+			continue
+		}
+		if keysEqual(keys, existing, element) {
+			return i
+		}
+	}
+	return -1
+}
+
+// keysEqual reports whether both elements have all the key fields, with
+// the same values. An element without a key field matches no element.
+func keysEqual(keys []string, lhs, rhs map[string]any) bool {
+	for _, key := range keys {
+		l, lok := lhs[key]
+		r, rok := rhs[key]
+		if !lok || !rok || !reflect.DeepEqual(l, r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,176 @@
+package mutator
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+)
+
+func containersSchema(extensions spec.Extensions) *spec.Schema {
+	return &spec.Schema{
+		SchemaProps: spec.SchemaProps{
+			Type: spec.StringOrArray{"object"},
+			Properties: map[string]spec.Schema{
+				"containers": {
+					VendorExtensible: spec.VendorExtensible{Extensions: extensions},
+					SchemaProps: spec.SchemaProps{
+						Type: spec.StringOrArray{"array"},
+						Items: &spec.SchemaOrArray{Schema: &spec.Schema{
+							SchemaProps: spec.SchemaProps{Type: spec.StringOrArray{"object"}},
+						}},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestListMergeByKeys(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		extensions spec.Extensions
+		expected   []any
+	}{
+		{
+			name:       "list-type map",
+			extensions: spec.Extensions{extListType: "map", extListMapKeys: []any{"name"}},
+			expected: []any{
+				map[string]any{"name": "nginx", "image": "nginx"},
+				map[string]any{"name": "sidecar", "image": "sidecar:v2"},
+			},
+		},
+		{
+			name:       "patch merge key",
+			extensions: spec.Extensions{extPatchStrategy: "merge", extPatchMergeKey: "name"},
+			expected: []any{
+				map[string]any{"name": "nginx", "image": "nginx"},
+				map[string]any{"name": "sidecar", "image": "sidecar:v2"},
+			},
+		},
+		{
+			name:       "atomic",
+			extensions: spec.Extensions{extListType: "atomic"},
+			expected: []any{
+				map[string]any{"name": "sidecar", "image": "sidecar:v2"},
+			},
+		},
+		{
+			name:       "set",
+			extensions: spec.Extensions{extListType: "set"},
+			expected: []any{
+				map[string]any{"name": "nginx", "image": "nginx"},
+				map[string]any{"name": "sidecar", "image": "sidecar:v1"},
+				map[string]any{"name": "sidecar", "image": "sidecar:v2"},
+			},
+		},
+		{
+			name: "unspecified",
+			expected: []any{
+				map[string]any{"name": "nginx", "image": "nginx"},
+				map[string]any{"name": "sidecar", "image": "sidecar:v1"},
+				map[string]any{"name": "sidecar", "image": "sidecar:v2"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := map[string]any{"containers": []any{
+				map[string]any{"name": "nginx", "image": "nginx"},
+				map[string]any{"name": "sidecar", "image": "sidecar:v1"},
+			}}
+			m := NewRootObjectMutatorWithSchema(root, containersSchema(tc.extensions))
+			list := m.(*objectMutator).Get(types.String("containers")).(Interface)
+			patch := []ref.Val{types.NewRefValMap(types.DefaultTypeAdapter, map[ref.Val]ref.Val{
+				types.String("name"):  types.String("sidecar"),
+				types.String("image"): types.String("sidecar:v2"),
+			})}
+			if result := list.Merge(patch); types.IsError(result) {
+				t.Fatal(result)
+			}
+			if !reflect.DeepEqual(root["containers"], tc.expected) {
+				t.Errorf("expected %v but got %v", tc.expected, root["containers"])
+			}
+		})
+	}
+}
+
+func TestListMergeByKeysErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		extensions  spec.Extensions
+		element     map[string]any
+		expectedErr error
+	}{
+		{
+			name:        "missing key",
+			extensions:  spec.Extensions{extListType: "map", extListMapKeys: []any{"name"}},
+			element:     map[string]any{"image": "sidecar:v2"},
+			expectedErr: ErrMissingListMapKey,
+		},
+		{
+			name:        "no keys",
+			extensions:  spec.Extensions{extListType: "map"},
+			element:     map[string]any{"name": "sidecar", "image": "sidecar:v2"},
+			expectedErr: ErrNoListMapKeys,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := map[string]any{"containers": []any{
+				map[string]any{"image": "nginx"},
+			}}
+			m := NewRootObjectMutatorWithSchema(root, containersSchema(tc.extensions))
+			list := m.(*objectMutator).Get(types.String("containers")).(Interface)
+			result := list.Merge(toRefVal([]any{tc.element}).Value())
+			if !types.IsError(result) || !errors.Is(result.(*types.Err).Unwrap(), tc.expectedErr) {
+				t.Fatalf("expected %v but got %v", tc.expectedErr, result)
+			}
+			expected := []any{map[string]any{"image": "nginx"}}
+			if !reflect.DeepEqual(root["containers"], expected) {
+				t.Errorf("unexpected change: %v", root["containers"])
+			}
+		})
+	}
+}
+
+func TestListRemove(t *testing.T) {
+	root := map[string]any{"containers": []any{
+		map[string]any{"name": "debug"},
+		map[string]any{"name": "nginx"},
+		map[string]any{"name": "debug-tools"},
+		map[string]any{"name": "sidecar"},
+	}}
+	m := NewRootObjectMutator(root)
+	list := m.(*objectMutator).Get(types.String("containers")).(*listMutator)
+	if result := list.RemoveElements([]int{0, 2}); types.IsError(result) {
+		t.Fatal(result)
+	}
+	element := list.Get(types.Int(1)).(Interface)
+	if result := element.Remove(); types.IsError(result) {
+		t.Fatal(result)
+	}
+	expected := []any{map[string]any{"name": "nginx"}}
+	if !reflect.DeepEqual(root["containers"], expected) {
+		t.Errorf("expected %v but got %v", expected, root["containers"])
+	}
+	if err := list.RemoveChild(1); err != ErrListIndexOutOfBound {
+		t.Errorf("expected out of bound error but got %v", err)
+	}
+	if result := list.Remove(); types.IsError(result) {
+		t.Fatal(result)
+	}
+	if _, exists := root["containers"]; exists {
+		t.Errorf("expected the list to be removed")
+	}
+	expectedPatch := JSONPatch{
+		{Op: PatchOpRemove, Path: "/containers/2"},
+		{Op: PatchOpRemove, Path: "/containers/0"},
+		{Op: PatchOpRemove, Path: "/containers/1"},
+		{Op: PatchOpRemove, Path: "/containers"},
+	}
+	if patch := JSONPatchOf(m); !reflect.DeepEqual(patch, expectedPatch) {
+		t.Errorf("expected patch %v but got %v", expectedPatch, patch)
+	}
+}
@@ -3,21 +3,45 @@ package mutator
 import (
 	"fmt"
 	"reflect"
+	"strings"
 
 	"github.com/google/cel-go/cel"
 	"github.com/google/cel-go/common/types"
 	"github.com/google/cel-go/common/types/ref"
 	"github.com/google/cel-go/common/types/traits"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/apply"
 )
 
-var ObjectMutatorType = cel.ObjectType("kubernetes.ObjectMutator", traits.IndexerType)
+// ObjectMutatorType is the type of object mutators. Object mutators are not
+// maps, so that the mutator functions cannot be called on plain maps, but
+// they have the traits of maps, so that they can be read like maps.
+var ObjectMutatorType = cel.ObjectType("kubernetes.ObjectMutator",
+	traits.ContainerType, traits.FieldTesterType, traits.IndexerType, traits.IterableType, traits.SizerType)
+
+// ObjectMutatorTypeOf returns the type of object mutators of which the values
+// are of the named type. Unlike ObjectMutatorType, the fields of such a type
+// can be known to the type checker.
+func ObjectMutatorTypeOf(name string) *cel.Type {
+	return cel.ObjectType(ObjectMutatorType.TypeName()+"."+name, traits.IndexerType)
+}
 
-var ErrNotObject = fmt.Errorf("not an list")
+// ObjectMutatorTypeParameter returns the name that the given type name is
+// created from by ObjectMutatorTypeOf, if any.
+func ObjectMutatorTypeParameter(typeName string) (string, bool) {
+	return strings.CutPrefix(typeName, ObjectMutatorType.TypeName()+".")
+}
+
+var ErrNotObject = fmt.Errorf("not an object")
 var ErrKeyNotFound = fmt.Errorf("key not found")
 
 type objectMutator struct {
 	object map[string]any
 
+	// recorder is only set on the root mutator.
+	recorder *patchRecorder
+
 	abstractMutator
 }
 
@@ -39,24 +63,36 @@ func (o *objectMutator) Child(identifier any) (any, bool) {
 
 func (o *objectMutator) RemoveChild(identifier any) error {
 	if s, ok := identifier.(string); ok {
+		if _, exists := o.object[s]; exists {
+			recorderOf(o).record(PatchOpRemove, childPointer(pointerOf(o), s), nil)
+		}
 		delete(o.object, s)
 		return nil
 	}
 	return fmt.Errorf("identifier has wrong type, expect string but got %t", identifier)
 }
 
+// readOnly returns the object as a read-only CEL map.
+func (o *objectMutator) readOnly() traits.Mapper {
+	return types.DefaultTypeAdapter.NativeToValue(o.object).(traits.Mapper)
+}
+
 func (o *objectMutator) ConvertToNative(typeDesc reflect.Type) (any, error) {
-	return nil, fmt.Errorf("disallowed conversion from %q to %q", ObjectMutatorType.TypeName(), typeDesc.Name())
+	return o.readOnly().ConvertToNative(typeDesc)
 }
 
 func (o *objectMutator) ConvertToType(typeValue ref.Type) ref.Val {
 	switch typeValue {
-	case ObjectMutatorType:
+	case types.MapType, ObjectMutatorType:
 		return o
 	case types.TypeType:
-		return ObjectMutatorType
+		return types.MapType
 	}
-	return types.NewErr("disallowed conversion from %q to %q", ObjectMutatorType.TypeName(), typeValue.TypeName())
+	return o.readOnly().ConvertToType(typeValue)
+}
+
+func (o *objectMutator) Equal(other ref.Val) ref.Val {
+	return o.readOnly().Equal(other)
 }
 
 func (o *objectMutator) Type() ref.Type {
@@ -64,53 +100,127 @@ func (o *objectMutator) Type() ref.Type {
 }
 
 func (o *objectMutator) Value() any {
-	return types.NoSuchOverloadErr()
+	return o.object
 }
 
 var _ Interface = (*objectMutator)(nil)
 var _ Container = (*objectMutator)(nil)
+var _ traits.Mapper = (*objectMutator)(nil)
+var _ traits.FieldTester = (*objectMutator)(nil)
 
+// Get returns the mutator of the field if the field is an object or a list,
+// or the value of the field otherwise.
 func (o *objectMutator) Get(index ref.Val) ref.Val {
 	f, ok := index.(types.String)
 	if !ok {
-		return types.MaybeNoSuchOverloadErr(f)
+		return types.MaybeNoSuchOverloadErr(index)
 	}
-	key := f.Value().(string)
-	if v, exists := o.object[f.Value().(string)]; exists {
-		switch v.(type) {
-		case map[string]any:
-			return mutatorOf(v, o, key)
-		case []any:
-			return mutatorOf(v, o, key)
-		default:
-			return types.NewErr("missing mutator for %t", v)
-		}
+	key := string(f)
+	if v, exists := o.object[key]; exists {
+		return mutatorOf(v, o, key)
 	}
 	return types.NewErr("no such key: %s", f)
 }
 
+func (o *objectMutator) Find(key ref.Val) (ref.Val, bool) {
+	f, ok := key.(types.String)
+	if !ok {
+		return nil, false
+	}
+	if _, exists := o.object[string(f)]; !exists {
+		return nil, false
+	}
+	return o.Get(f), true
+}
+
+// IsSet tests the presence of the field, for has() and optional field
+// selection.
+func (o *objectMutator) IsSet(field ref.Val) ref.Val {
+	f, ok := field.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(field)
+	}
+	_, exists := o.object[string(f)]
+	return types.Bool(exists)
+}
+
+func (o *objectMutator) Contains(key ref.Val) ref.Val {
+	_, found := o.Find(key)
+	return types.Bool(found)
+}
+
+func (o *objectMutator) Iterator() traits.Iterator {
+	return o.readOnly().Iterator()
+}
+
+func (o *objectMutator) Size() ref.Val {
+	return types.Int(len(o.object))
+}
+
 func (o *objectMutator) Merge(rhs any) ref.Val {
-	patch, ok := rhs.(map[ref.Val]ref.Val)
+	native, err := toNative(rhs)
+	if err != nil {
+		return types.WrapErr(err)
+	}
+	patch, ok := native.(map[string]any)
 	if !ok {
 		return types.NoSuchOverloadErr()
 	}
-	return mergeObject(o.object, patch)
+	return o.mergeObject(patch)
 }
 
 func (o *objectMutator) Remove() ref.Val {
-	if container, ok := o.Parent().(Container); ok && o.Identifier() != nil {
-		err := container.RemoveChild(o.Identifier())
-		if err != nil {
-			return types.WrapErr(err)
-		}
-		return types.NullValue
+	return removeFromParent(o)
+}
+
+func (o *objectMutator) Set(value ref.Val) ref.Val {
+	container, _ := o.Parent().(Container)
+	native, err := refToNative(value)
+	if err != nil {
+		return types.WrapErr(err)
+	}
+	result := setChild(container, o.Identifier(), o.tracker, native)
+	if object, ok := native.(map[string]any); ok && !types.IsError(result) {
+		o.object = object
+	}
+	return result
+}
+
+// SetField sets the named field to the value, adding the field if missing.
+func (o *objectMutator) SetField(name string, value ref.Val) ref.Val {
+	t, err := advanceField(o.tracker, childPath(pathOf(o), name), name)
+	if err != nil {
+		return types.WrapErr(err)
 	}
-	return types.NoSuchOverloadErr()
+	native, err := refToNative(value)
+	if err != nil {
+		return types.WrapErr(err)
+	}
+	return setChild(o, name, t, native)
 }
 
 func NewRootObjectMutator(root map[string]any) Interface {
+	return NewRootObjectMutatorWithSchema(root, nil)
+}
+
+// NewRootObjectMutatorWithSchema creates a root mutator of which the value
+// is described by the given schema. Child mutators inherit the corresponding
+// part of the schema.
+func NewRootObjectMutatorWithSchema(root map[string]any, schema *spec.Schema) Interface {
+	if schema == nil {
+		return NewRootObjectMutatorWithTracker(root, nil)
+	}
+	return NewRootObjectMutatorWithTracker(root, apply.NewSchemaTracker(schema, nil))
+}
+
+// NewRootObjectMutatorWithTracker is like NewRootObjectMutatorWithSchema, but
+// takes a tracker at the schema, of which the references are resolved as
+// child mutators are created.
+func NewRootObjectMutatorWithTracker(root map[string]any, tracker *apply.SchemaTracker) Interface {
 	mutator := new(objectMutator)
 	mutator.object = root
+	mutator.tracker = tracker
+	mutator.recorder = new(patchRecorder)
 	return mutator
 }
 
@@ -127,56 +237,58 @@ func NewObjectMutator(parent Container, key any) (Interface, error) {
 	mutator.parent = parent
 	mutator.object = object
 	mutator.identifier = key
+	mutator.tracker = advance(trackerOf(parent), key)
 	return mutator, nil
 }
 
 var _ traits.Indexer = (*objectMutator)(nil)
 
-func mergeObject(lhs map[string]any, rhs map[ref.Val]ref.Val) ref.Val {
-	for key := range rhs {
-		name, ok := key.Value().(string)
-		if !ok {
-			return types.NewErr("bad map key: %v", key.Value())
-		}
-		val := rhs[key].Value()
-		switch val.(type) {
-		case []ref.Val:
-			return types.NewErr("array cannot merge with list")
-		case map[ref.Val]ref.Val:
-			lhs[name] = refMapToNative(val.(map[ref.Val]ref.Val))
-		default:
-			lhs[name] = val
-		}
+// mergeObject merges the patch into lhs following JSON merge patch
+// (RFC 7386): nested objects are merged recursively, a null value removes
+// the field, and any other value, including a list, replaces the field.
+// If the schema of the object is known, the patch is validated before any
+// change is made.
+func (o *objectMutator) mergeObject(patch map[string]any) ref.Val {
+	if err := validatePatch(o.tracker, pathOf(o), patch); err != nil {
+		return types.WrapErr(err)
 	}
+	mergePatch(o.object, patch, pointerOf(o), recorderOf(o))
 	return types.Null(0)
 }
 
-func refMapToNative(refMap map[ref.Val]ref.Val) map[string]any {
-	ret := make(map[string]any)
-	for kv, vv := range refMap {
-		v := vv.Value()
-		switch v.(type) {
-		case []ref.Val:
-			v = refSliceToNative(v.([]ref.Val))
-		case map[ref.Val]ref.Val:
-			v = refMapToNative(v.(map[ref.Val]ref.Val))
+// mergePatch recursively merges the native patch into target in place,
+// recording the changes under the given JSON pointer, and returns the target.
+func mergePatch(target map[string]any, patch map[string]any, pointer string, r *patchRecorder) map[string]any {
+	for name, val := range patch {
+		fieldPointer := childPointer(pointer, name)
+		existing, exists := target[name]
+		switch val := val.(type) {
+		case nil:
+			if exists {
+				r.record(PatchOpRemove, fieldPointer, nil)
+			}
+			delete(target, name)
+		case map[string]any:
+			if existingObject, ok := existing.(map[string]any); ok {
+				target[name] = mergePatch(existingObject, val, fieldPointer, r)
+				continue
+			}
+			target[name] = mergePatch(make(map[string]any, len(val)), val, fieldPointer, nil)
+			r.record(addOrReplace(exists), fieldPointer, target[name])
+		default:
+			if exists && reflect.DeepEqual(existing, val) {
+				continue
+			}
+			target[name] = val
+			r.record(addOrReplace(exists), fieldPointer, val)
 		}
-		ret[kv.Value().(string)] = v
 	}
-	return ret
+	return target
 }
 
-func refSliceToNative(refSlice []ref.Val) []any {
-	ret := make([]any, 0, len(refSlice))
-	for _, vv := range refSlice {
-		v := vv.Value()
-		switch v.(type) {
-		case []ref.Val:
-			v = refSliceToNative(v.([]ref.Val))
-		case map[ref.Val]ref.Val:
-			v = refMapToNative(v.(map[ref.Val]ref.Val))
-		}
-		ret = append(ret, v)
+func addOrReplace(exists bool) string {
+	if exists {
+		return PatchOpReplace
 	}
-	return ret
+	return PatchOpAdd
 }
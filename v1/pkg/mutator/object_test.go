@@ -0,0 +1,114 @@
+package mutator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+// toRefVal converts a native value into a CEL value the same way as
+// a CEL literal would be.
+func toRefVal(v any) ref.Val {
+	switch v := v.(type) {
+	case map[string]any:
+		m := make(map[ref.Val]ref.Val, len(v))
+		for key, val := range v {
+			m[types.String(key)] = toRefVal(val)
+		}
+		return types.NewRefValMap(types.DefaultTypeAdapter, m)
+	case []any:
+		l := make([]ref.Val, 0, len(v))
+		for _, val := range v {
+			l = append(l, toRefVal(val))
+		}
+		return types.NewRefValList(types.DefaultTypeAdapter, l)
+	default:
+		return types.DefaultTypeAdapter.NativeToValue(v)
+	}
+}
+
+func TestObjectMergePatch(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		object   map[string]any
+		patch    map[string]any
+		expected map[string]any
+	}{
+		{
+			name: "nested merge keeps siblings",
+			object: map[string]any{"strategy": map[string]any{
+				"type":          "RollingUpdate",
+				"rollingUpdate": map[string]any{"maxSurge": "25%", "maxUnavailable": "25%"},
+			}},
+			patch: map[string]any{"strategy": map[string]any{
+				"rollingUpdate": map[string]any{"maxUnavailable": int64(1)},
+			}},
+			expected: map[string]any{"strategy": map[string]any{
+				"type":          "RollingUpdate",
+				"rollingUpdate": map[string]any{"maxSurge": "25%", "maxUnavailable": int64(1)},
+			}},
+		},
+		{
+			name:     "null removes the field",
+			object:   map[string]any{"replicas": int64(1), "paused": true},
+			patch:    map[string]any{"paused": nil},
+			expected: map[string]any{"replicas": int64(1)},
+		},
+		{
+			name:     "null inside a new object is dropped",
+			object:   map[string]any{},
+			patch:    map[string]any{"strategy": map[string]any{"type": "Recreate", "rollingUpdate": nil}},
+			expected: map[string]any{"strategy": map[string]any{"type": "Recreate"}},
+		},
+		{
+			name:     "object replaces a scalar",
+			object:   map[string]any{"strategy": "Recreate"},
+			patch:    map[string]any{"strategy": map[string]any{"type": "Recreate"}},
+			expected: map[string]any{"strategy": map[string]any{"type": "Recreate"}},
+		},
+		{
+			name:     "list replaces a list",
+			object:   map[string]any{"args": []any{"a", "b"}},
+			patch:    map[string]any{"args": []any{"c"}},
+			expected: map[string]any{"args": []any{"c"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewRootObjectMutator(tc.object)
+			if result := m.Merge(toRefVal(tc.patch).Value()); types.IsError(result) {
+				t.Fatal(result)
+			}
+			if !reflect.DeepEqual(tc.object, tc.expected) {
+				t.Errorf("expected %v but got %v", tc.expected, tc.object)
+			}
+		})
+	}
+}
+
+func TestObjectPresence(t *testing.T) {
+	root := map[string]any{"spec": map[string]any{"replicas": int64(1), "selector": nil}}
+	spec := NewRootObjectMutator(root).(*objectMutator).Get(types.String("spec")).(*objectMutator)
+	for _, tc := range []struct {
+		field    string
+		expected bool
+	}{
+		{field: "replicas", expected: true},
+		{field: "selector", expected: true},
+		{field: "strategy", expected: false},
+	} {
+		if isSet := spec.IsSet(types.String(tc.field)); isSet != types.Bool(tc.expected) {
+			t.Errorf("%s: expected IsSet to be %v but got %v", tc.field, tc.expected, isSet)
+		}
+		if _, found := spec.Find(types.String(tc.field)); found != tc.expected {
+			t.Errorf("%s: expected Find to find %v but got %v", tc.field, tc.expected, found)
+		}
+	}
+	if v := spec.Get(types.String("strategy")); !types.IsError(v) {
+		t.Errorf("expected error getting a missing field but got %v", v)
+	}
+	if v := spec.IsSet(types.Int(0)); !types.IsError(v) {
+		t.Errorf("expected error testing a non-string field but got %v", v)
+	}
+}
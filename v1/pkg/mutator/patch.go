@@ -0,0 +1,121 @@
+package mutator
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+)
+
+const (
+	PatchOpAdd     = "add"
+	PatchOpReplace = "replace"
+	PatchOpRemove  = "remove"
+)
+
+// PatchOperation is a single operation of a JSONPatch (RFC 6902).
+type PatchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value,omitempty"`
+}
+
+// MarshalJSON omits the value of remove operations only, because the value
+// of other operations is required even if it is null.
+func (o PatchOperation) MarshalJSON() ([]byte, error) {
+	if o.Op == PatchOpRemove {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{o.Op, o.Path})
+	}
+	return json.Marshal(struct {
+		Op    string `json:"op"`
+		Path  string `json:"path"`
+		Value any    `json:"value"`
+	}{o.Op, o.Path, o.Value})
+}
+
+// JSONPatch is a list of operations that, applied in order to the original
+// object, produces the mutated object.
+type JSONPatch []PatchOperation
+
+// patchRecorder records the changes made by the mutators sharing the same
+// root, and the fields that have been applied. A nil recorder records
+// nothing.
+type patchRecorder struct {
+	patch   JSONPatch
+	applied *fieldpath.Set
+}
+
+func (r *patchRecorder) record(op string, pointer string, value any) {
+	if r == nil {
+		return
+	}
+	operation := PatchOperation{Op: op, Path: pointer}
+	if op != PatchOpRemove {
+		// the value may be mutated in place afterward
+		operation.Value = deepCopy(value)
+	}
+	r.patch = append(r.patch, operation)
+}
+
+// JSONPatchOf returns the changes made through the root mutator and all its
+// descendants so far, or nil if the mutator is not a root mutator.
+func JSONPatchOf(root Interface) JSONPatch {
+	o, ok := root.(*objectMutator)
+	if !ok || o.recorder == nil {
+		return nil
+	}
+	return append(JSONPatch{}, o.recorder.patch...)
+}
+
+// recorderOf returns the recorder of the root of the mutator.
+func recorderOf(m Interface) *patchRecorder {
+	for m.Parent() != nil {
+		m = m.Parent()
+	}
+	if root, ok := m.(*objectMutator); ok {
+		return root.recorder
+	}
+	return nil
+}
+
+// pointerOf returns the JSON pointer (RFC 6901) of the mutator from the root.
+func pointerOf(m Interface) string {
+	var identifiers []any
+	for ; m != nil && m.Parent() != nil; m = m.Parent() {
+		identifiers = append(identifiers, m.Identifier())
+	}
+	var sb strings.Builder
+	for i := len(identifiers) - 1; i >= 0; i-- {
+		sb.WriteString(childPointer("", identifiers[i]))
+	}
+	return sb.String()
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+func childPointer(pointer string, identifier any) string {
+	return pointer + "/" + pointerEscaper.Replace(fmt.Sprint(identifier))
+}
+
+func deepCopy(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(v))
+		for key, val := range v {
+			c[key] = deepCopy(val)
+		}
+		return c
+	case []any:
+		c := make([]any, len(v))
+		for i, val := range v {
+			c[i] = deepCopy(val)
+		}
+		return c
+	default:
+		return v
+	}
+}
@@ -0,0 +1,119 @@
+package mutator
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+func TestJSONPatch(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		mutate   func(root *objectMutator) ref.Val
+		expected JSONPatch
+	}{
+		{
+			name: "merge",
+			mutate: func(root *objectMutator) ref.Val {
+				spec := root.Get(types.String("spec")).(Interface)
+				return spec.Merge(toRefVal(map[string]any{
+					"replicas": int64(3),
+					"paused":   false,
+					"strategy": map[string]any{"type": "Recreate"},
+					"template": map[string]any{"spec": map[string]any{"hostNetwork": true}},
+				}).Value())
+			},
+			expected: JSONPatch{
+				{Op: PatchOpAdd, Path: "/spec/paused", Value: false},
+				{Op: PatchOpReplace, Path: "/spec/replicas", Value: int64(3)},
+				{Op: PatchOpAdd, Path: "/spec/strategy", Value: map[string]any{"type": "Recreate"}},
+				{Op: PatchOpAdd, Path: "/spec/template/spec/hostNetwork", Value: true},
+			},
+		},
+		{
+			name: "unchanged merge",
+			mutate: func(root *objectMutator) ref.Val {
+				spec := root.Get(types.String("spec")).(Interface)
+				return spec.Merge(toRefVal(map[string]any{"replicas": int64(1)}).Value())
+			},
+		},
+		{
+			name: "remove",
+			mutate: func(root *objectMutator) ref.Val {
+				return root.Get(types.String("spec")).(*objectMutator).Get(types.String("template")).(Interface).Remove()
+			},
+			expected: JSONPatch{
+				{Op: PatchOpRemove, Path: "/spec/template"},
+			},
+		},
+		{
+			name: "null",
+			mutate: func(root *objectMutator) ref.Val {
+				spec := root.Get(types.String("spec")).(Interface)
+				return spec.Merge(map[ref.Val]ref.Val{types.String("replicas"): types.NullValue})
+			},
+			expected: JSONPatch{
+				{Op: PatchOpRemove, Path: "/spec/replicas"},
+			},
+		},
+		{
+			name: "list",
+			mutate: func(root *objectMutator) ref.Val {
+				containers := root.Ensure("spec", "template", "spec", "containers").(Interface)
+				return containers.Merge(toRefVal([]any{
+					map[string]any{"name": "nginx", "image": "nginx:latest"},
+					map[string]any{"name": "sidecar", "image": "sidecar"},
+				}).Value())
+			},
+			expected: JSONPatch{
+				{Op: PatchOpReplace, Path: "/spec/template/spec/containers/0/image", Value: "nginx:latest"},
+				{Op: PatchOpAdd, Path: "/spec/template/spec/containers/1", Value: map[string]any{"name": "sidecar", "image": "sidecar"}},
+			},
+		},
+		{
+			name: "ensure",
+			mutate: func(root *objectMutator) ref.Val {
+				return root.Ensure("metadata", "annotations")
+			},
+			expected: JSONPatch{
+				{Op: PatchOpAdd, Path: "/metadata", Value: map[string]any{}},
+				{Op: PatchOpAdd, Path: "/metadata/annotations", Value: map[string]any{}},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := NewRootObjectMutatorWithSchema(newDeployment(), loadDeploymentSchema(t)).(*objectMutator)
+			if result := tc.mutate(root); types.IsError(result) {
+				t.Fatal(result)
+			}
+			patch := JSONPatchOf(root)
+			sortPatch(patch)
+			if len(patch) != len(tc.expected) || len(patch) > 0 && !reflect.DeepEqual(patch, tc.expected) {
+				t.Errorf("expected %v but got %v", tc.expected, patch)
+			}
+		})
+	}
+}
+
+func TestPointerEscape(t *testing.T) {
+	root := NewRootObjectMutator(map[string]any{"metadata": map[string]any{}}).(*objectMutator)
+	metadata := root.Get(types.String("metadata")).(Interface)
+	metadata.Merge(toRefVal(map[string]any{"annotations": map[string]any{}}).Value())
+	annotations := metadata.(*objectMutator).Get(types.String("annotations")).(Interface)
+	annotations.Merge(toRefVal(map[string]any{"example.com/a~b": "c"}).Value())
+	patch := JSONPatchOf(root)
+	if len(patch) != 2 || patch[1].Path != "/metadata/annotations/example.com~1a~0b" {
+		t.Errorf("unexpected patch: %v", patch)
+	}
+}
+
+// sortPatch sorts operations by their paths, as the order of the fields in
+// a merge is undefined.
+func sortPatch(patch JSONPatch) {
+	sort.SliceStable(patch, func(i, j int) bool {
+		return patch[i].Path < patch[j].Path
+	})
+}
@@ -0,0 +1,341 @@
+package mutator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"sigs.k8s.io/structured-merge-diff/v4/typed"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/apply"
+)
+
+const (
+	extListType              = "x-kubernetes-list-type"
+	extListMapKeys           = "x-kubernetes-list-map-keys"
+	extPatchMergeKey         = "x-kubernetes-patch-merge-key"
+	extPatchStrategy         = "x-kubernetes-patch-strategy"
+	extPreserveUnknownFields = "x-kubernetes-preserve-unknown-fields"
+	extIntOrString           = "x-kubernetes-int-or-string"
+)
+
+const (
+	listTypeAtomic = "atomic"
+	listTypeSet    = "set"
+	listTypeMap    = "map"
+)
+
+var ErrUnknownField = fmt.Errorf("unknown field")
+var ErrTypeMismatch = fmt.Errorf("type mismatch")
+var ErrMissingListMapKey = fmt.Errorf("missing key of associative list")
+var ErrNoListMapKeys = fmt.Errorf("associative list without keys")
+
+// trackerOf returns the tracker at the schema of the mutator, or nil if the
+// schema is unknown.
+func trackerOf(m Interface) *apply.SchemaTracker {
+	if t, ok := m.(interface{ schemaTracker() *apply.SchemaTracker }); ok {
+		return t.schemaTracker()
+	}
+	return nil
+}
+
+// advance returns the tracker at the schema of the child identified by the
+// key, a field name or an index, or nil if the schema of the child is
+// unknown.
+func advance(t *apply.SchemaTracker, key any) *apply.SchemaTracker {
+	if t == nil {
+		return nil
+	}
+	var child *apply.SchemaTracker
+	var err error
+	switch k := key.(type) {
+	case string:
+		child, err = t.Advance(k)
+	case int:
+		child, err = t.AdvanceIndex(k)
+	default:
+		return nil
+	}
+	if err != nil {
+		return nil
+	}
+	return child
+}
+
+// advanceField returns the tracker at the schema of the named field of an
+// object, or nil if the schema does not restrict the field. A field that the
+// schema does not allow is an ErrUnknownField.
+func advanceField(t *apply.SchemaTracker, path *field.Path, name string) (*apply.SchemaTracker, error) {
+	if t == nil {
+		return nil, nil
+	}
+	child, err := t.Advance(name)
+	if err == nil {
+		return child, nil
+	}
+	if !errors.Is(err, apply.ErrFieldNotFound) {
+		return nil, err
+	}
+	s := t.Schema()
+	if s.AdditionalProperties != nil && s.AdditionalProperties.Allows {
+		return nil, nil
+	}
+	if preserveUnknownFields(s) || len(s.Properties) == 0 && s.AdditionalProperties == nil {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("%s: %w", path, ErrUnknownField)
+}
+
+// schemaOf returns the schema at which the tracker is, or nil.
+func schemaOf(t *apply.SchemaTracker) *spec.Schema {
+	if t == nil {
+		return nil
+	}
+	return t.Schema()
+}
+
+// typeOf returns the structured-merge-diff type of the schema at which the
+// tracker is, or the deduced type if the tracker is nil. See apply.TypeOf.
+func typeOf(t *apply.SchemaTracker) (*typed.ParseableType, error) {
+	if t == nil {
+		return apply.TypeOf(nil)
+	}
+	return t.Type()
+}
+
+// listType returns the x-kubernetes-list-type of the list, "map" for lists
+// with a patch merge key, or an empty string if unspecified.
+func listType(s *spec.Schema) string {
+	if s == nil {
+		return ""
+	}
+	if t, _ := s.Extensions.GetString(extListType); t != "" {
+		return t
+	}
+	if len(listMapKeys(s)) > 0 {
+		return listTypeMap
+	}
+	return ""
+}
+
+// listMapKeys returns the key fields of an associative list, or nil if the
+// list is not associative. Following structured-merge-diff, lists with the
+// "merge" patch strategy and a patch merge key are also associative.
+func listMapKeys(s *spec.Schema) []string {
+	if s == nil {
+		return nil
+	}
+	if listType, _ := s.Extensions.GetString(extListType); listType == "map" {
+		keys, _ := s.Extensions.GetStringSlice(extListMapKeys)
+		return keys
+	}
+	strategy, _ := s.Extensions.GetString(extPatchStrategy)
+	key, _ := s.Extensions.GetString(extPatchMergeKey)
+	if key != "" && strings.Contains(strategy, "merge") {
+		return []string{key}
+	}
+	return nil
+}
+
+// pathOf returns the path of the mutator from the root.
+func pathOf(m Interface) *field.Path {
+	var identifiers []any
+	for ; m != nil && m.Parent() != nil; m = m.Parent() {
+		identifiers = append(identifiers, m.Identifier())
+	}
+	var path *field.Path
+	for i := len(identifiers) - 1; i >= 0; i-- {
+		path = childPath(path, identifiers[i])
+	}
+	return path
+}
+
+func childPath(path *field.Path, identifier any) *field.Path {
+	switch id := identifier.(type) {
+	case int:
+		if path == nil {
+			return field.NewPath("").Index(id)
+		}
+		return path.Index(id)
+	default:
+		if path == nil {
+			return field.NewPath(fmt.Sprint(id))
+		}
+		return path.Child(fmt.Sprint(id))
+	}
+}
+
+// validatePatch checks that the given JSON merge patch conforms to the
+// schema at which the tracker is. A null value is allowed for any field
+// because it removes the field.
+func validatePatch(t *apply.SchemaTracker, path *field.Path, patch map[string]any) error {
+	if t == nil {
+		return nil
+	}
+	for name, value := range patch {
+		fieldPath := childPath(path, name)
+		fieldTracker, err := advanceField(t, fieldPath, name)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			continue
+		}
+		if nested, ok := value.(map[string]any); ok && fieldTracker != nil && isType(fieldTracker.Schema(), "object") {
+			if err := validatePatch(fieldTracker, fieldPath, nested); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := validateValue(fieldTracker, fieldPath, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateValue checks that the value conforms to the schema at which the
+// tracker is.
+func validateValue(t *apply.SchemaTracker, path *field.Path, value any) error {
+	if t == nil {
+		return nil
+	}
+	s := t.Schema()
+	if value == nil {
+		if s.Nullable || len(s.Type) == 0 {
+			return nil
+		}
+		return typeMismatch(path, s, value)
+	}
+	if isIntOrString(s) {
+		switch value.(type) {
+		case string, int, int32, int64:
+			return nil
+		}
+		return fmt.Errorf("%s: %w: expected integer or string but got %s", path, ErrTypeMismatch, jsonTypeOf(value))
+	}
+	if len(s.Type) == 0 {
+		alternatives := t.Alternatives()
+		if len(alternatives) == 0 {
+			return nil
+		}
+		var err error
+		for _, alternative := range alternatives {
+			if err = validateValue(alternative, path, value); err == nil {
+				return nil
+			}
+		}
+		return err
+	}
+	switch {
+	case isType(s, "object"):
+		object, ok := value.(map[string]any)
+		if !ok {
+			return typeMismatch(path, s, value)
+		}
+		for name, v := range object {
+			fieldPath := childPath(path, name)
+			fieldTracker, err := advanceField(t, fieldPath, name)
+			if err != nil {
+				return err
+			}
+			if err := validateValue(fieldTracker, fieldPath, v); err != nil {
+				return err
+			}
+		}
+	case isType(s, "array"):
+		list, ok := value.([]any)
+		if !ok {
+			return typeMismatch(path, s, value)
+		}
+		for i, v := range list {
+			if err := validateValue(advance(t, i), childPath(path, i), v); err != nil {
+				return err
+			}
+		}
+	case isType(s, "string"):
+		if _, ok := value.(string); !ok {
+			return typeMismatch(path, s, value)
+		}
+	case isType(s, "integer"):
+		switch value.(type) {
+		case int, int32, int64:
+		default:
+			return typeMismatch(path, s, value)
+		}
+	case isType(s, "number"):
+		switch value.(type) {
+		case int, int32, int64, float32, float64:
+		default:
+			return typeMismatch(path, s, value)
+		}
+	case isType(s, "boolean"):
+		if _, ok := value.(bool); !ok {
+			return typeMismatch(path, s, value)
+		}
+	}
+	return nil
+}
+
+// validateMapElements checks that every element to merge into an
+// associative list is an object with all the key fields, so that the
+// element is identified by its keys. A list that declares no key fields
+// cannot be associative.
+func validateMapElements(keys []string, path *field.Path, elements []any) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("%s: %w: %s is missing", path, ErrNoListMapKeys, extListMapKeys)
+	}
+	for i, element := range elements {
+		object, ok := element.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: %w: expected object but got %s", childPath(path, i), ErrTypeMismatch, jsonTypeOf(element))
+		}
+		for _, key := range keys {
+			if _, ok := object[key]; !ok {
+				return fmt.Errorf("%s: %w: %q", childPath(path, i), ErrMissingListMapKey, key)
+			}
+		}
+	}
+	return nil
+}
+
+func typeMismatch(path *field.Path, s *spec.Schema, value any) error {
+	return fmt.Errorf("%s: %w: expected %s but got %s", path, ErrTypeMismatch, s.Type[0], jsonTypeOf(value))
+}
+
+func isType(s *spec.Schema, t string) bool {
+	return len(s.Type) > 0 && s.Type[0] == t
+}
+
+func isIntOrString(s *spec.Schema) bool {
+	v, _ := s.Extensions.GetBool(extIntOrString)
+	return v || s.Format == "int-or-string"
+}
+
+func preserveUnknownFields(s *spec.Schema) bool {
+	v, _ := s.Extensions.GetBool(extPreserveUnknownFields)
+	return v
+}
+
+func jsonTypeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case int, int32, int64:
+		return "integer"
+	case float32, float64:
+		return "number"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
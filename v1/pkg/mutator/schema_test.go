@@ -0,0 +1,196 @@
+package mutator
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/apply"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/openapi"
+)
+
+func loadDeploymentSchema(t *testing.T) *spec.Schema {
+	f, err := os.Open("../../testdata/deploy.schema.json")
+	if err != nil {
+		t.Fatalf("cannot load schema file: %v", err)
+	}
+	defer f.Close()
+	s, err := openapi.LoadSchema(f)
+	if err != nil {
+		t.Fatalf("cannot load schema: %v", err)
+	}
+	return s
+}
+
+func newDeployment() map[string]any {
+	return map[string]any{
+		"spec": map[string]any{
+			"replicas": int64(1),
+			"template": map[string]any{
+				"spec": map[string]any{
+					"containers": []any{
+						map[string]any{"name": "nginx", "image": "nginx"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestSchemaAwareMerge(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		path        []string
+		patch       any
+		expectedErr error
+		errorPath   string
+	}{
+		{
+			name:  "valid nested patch",
+			path:  []string{"spec"},
+			patch: map[string]any{"strategy": map[string]any{"rollingUpdate": map[string]any{"maxUnavailable": "25%"}}},
+		},
+		{
+			name:  "null removes a known field",
+			path:  []string{"spec"},
+			patch: map[string]any{"replicas": nil},
+		},
+		{
+			name:        "unknown field",
+			path:        []string{"spec"},
+			patch:       map[string]any{"replcas": int64(3)},
+			expectedErr: ErrUnknownField,
+			errorPath:   "spec.replcas",
+		},
+		{
+			name:        "wrong type",
+			path:        []string{"spec"},
+			patch:       map[string]any{"replicas": "three"},
+			expectedErr: ErrTypeMismatch,
+			errorPath:   "spec.replicas",
+		},
+		{
+			name:        "wrong int-or-string",
+			path:        []string{"spec"},
+			patch:       map[string]any{"strategy": map[string]any{"rollingUpdate": map[string]any{"maxSurge": true}}},
+			expectedErr: ErrTypeMismatch,
+			errorPath:   "spec.strategy.rollingUpdate.maxSurge",
+		},
+		{
+			name:  "quantity accepts a number",
+			path:  []string{"spec", "template", "spec", "containers"},
+			patch: []any{map[string]any{"name": "nginx", "resources": map[string]any{"limits": map[string]any{"cpu": int64(1)}}}},
+		},
+		{
+			name:        "unknown field in list element",
+			path:        []string{"spec", "template", "spec", "containers"},
+			patch:       []any{map[string]any{"name": "sidecar", "imag": "sidecar"}},
+			expectedErr: ErrUnknownField,
+			errorPath:   "spec.template.spec.containers[0].imag",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var m ref.Val = NewRootObjectMutatorWithSchema(newDeployment(), loadDeploymentSchema(t))
+			for _, p := range tc.path {
+				m = m.(*objectMutator).Get(types.String(p))
+			}
+			result := m.(Interface).Merge(toRefVal(tc.patch).Value())
+			if tc.expectedErr == nil {
+				if types.IsError(result) {
+					t.Fatal(result)
+				}
+				return
+			}
+			if !types.IsError(result) {
+				t.Fatalf("expected error but got %v", result)
+			}
+			err := result.(*types.Err).Unwrap()
+			if !errors.Is(err, tc.expectedErr) || !strings.HasPrefix(err.Error(), tc.errorPath+":") {
+				t.Errorf("expected %q error at %s but got %v", tc.expectedErr, tc.errorPath, err)
+			}
+		})
+	}
+}
+
+func TestSchemaReferences(t *testing.T) {
+	ref := func(name string) *spec.Schema {
+		return spec.RefSchema("#/components/schemas/" + name)
+	}
+	components := map[string]*spec.Schema{
+		"DeploymentSpec": {SchemaProps: spec.SchemaProps{
+			Type: []string{"object"},
+			Properties: map[string]spec.Schema{
+				"replicas": *spec.Int32Property(),
+				"containers": {
+					VendorExtensible: spec.VendorExtensible{Extensions: spec.Extensions{
+						extListType:    "map",
+						extListMapKeys: []any{"name"},
+					}},
+					SchemaProps: spec.SchemaProps{
+						Type:  []string{"array"},
+						Items: &spec.SchemaOrArray{Schema: ref("Container")},
+					},
+				},
+			},
+		}},
+		"Container": {SchemaProps: spec.SchemaProps{
+			Type: []string{"object"},
+			Properties: map[string]spec.Schema{
+				"name":  *spec.StringProperty(),
+				"image": *spec.StringProperty(),
+			},
+		}},
+	}
+	root := &spec.Schema{SchemaProps: spec.SchemaProps{
+		Type: []string{"object"},
+		Properties: map[string]spec.Schema{
+			"spec": {SchemaProps: spec.SchemaProps{AllOf: []spec.Schema{*ref("DeploymentSpec")}}},
+		},
+	}}
+	newSpec := func() *objectMutator {
+		object := map[string]any{"spec": map[string]any{
+			"replicas":   int64(1),
+			"containers": []any{map[string]any{"name": "nginx", "image": "nginx"}},
+		}}
+		m := NewRootObjectMutatorWithTracker(object, apply.NewSchemaTracker(root, components))
+		return m.(*objectMutator).Get(types.String("spec")).(*objectMutator)
+	}
+
+	for patch, expectedErr := range map[string]error{
+		"replicas":  ErrTypeMismatch,
+		"replica":   ErrUnknownField,
+		"container": ErrUnknownField,
+	} {
+		result := newSpec().Merge(toRefVal(map[string]any{patch: "3"}).Value())
+		if !types.IsError(result) || !errors.Is(result.(*types.Err).Unwrap(), expectedErr) {
+			t.Errorf("%s: expected %v but got %v", patch, expectedErr, result)
+		}
+	}
+	if result := newSpec().SetField("replicas", types.String("3")); !types.IsError(result) {
+		t.Errorf("expected the scalar to be validated, but got %v", result)
+	}
+
+	deploymentSpec := newSpec()
+	containers := deploymentSpec.Get(types.String("containers")).(Interface)
+	if result := containers.Merge(toRefVal([]any{map[string]any{"name": "nginx", "image": "nginx:1.25"}}).Value()); types.IsError(result) {
+		t.Fatal(result)
+	}
+	expected := []any{map[string]any{"name": "nginx", "image": "nginx:1.25"}}
+	if !reflect.DeepEqual(deploymentSpec.object["containers"], expected) {
+		t.Errorf("expected the list to be merged by keys, but got %v", deploymentSpec.object["containers"])
+	}
+	if result := deploymentSpec.Apply(toRefVal(map[string]any{
+		"containers": []any{map[string]any{"name": "sidecar", "image": "busybox"}},
+	})); types.IsError(result) {
+		t.Fatal(result)
+	}
+	if n := len(deploymentSpec.object["containers"].([]any)); n != 2 {
+		t.Errorf("expected the applied list to be merged by keys, but got %v", deploymentSpec.object["containers"])
+	}
+}
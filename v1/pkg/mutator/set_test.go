@@ -0,0 +1,130 @@
+package mutator
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+func TestScalarSet(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		withSchema    bool
+		set           func(spec *objectMutator) ref.Val
+		expectedSpec  map[string]any
+		expectedPatch JSONPatch
+		expectedErr   error
+	}{
+		{
+			name: "integer",
+			set: func(spec *objectMutator) ref.Val {
+				return spec.SetField("replicas", types.Int(3))
+			},
+			expectedSpec:  map[string]any{"replicas": int64(3)},
+			expectedPatch: JSONPatch{{Op: PatchOpReplace, Path: "/spec/replicas", Value: int64(3)}},
+		},
+		{
+			name: "unchanged",
+			set: func(spec *objectMutator) ref.Val {
+				return spec.SetField("replicas", types.Int(1))
+			},
+			expectedSpec: map[string]any{"replicas": int64(1)},
+		},
+		{
+			name: "null",
+			set: func(spec *objectMutator) ref.Val {
+				return spec.SetField("replicas", types.NullValue)
+			},
+			expectedSpec:  map[string]any{"replicas": nil},
+			expectedPatch: JSONPatch{{Op: PatchOpReplace, Path: "/spec/replicas", Value: nil}},
+		},
+		{
+			name: "new fields",
+			set: func(spec *objectMutator) ref.Val {
+				for name, value := range map[string]ref.Val{
+					"paused":   types.True,
+					"ratio":    types.Double(0.5),
+					"strategy": types.String("Recreate"),
+				} {
+					if result := spec.SetField(name, value); types.IsError(result) {
+						return result
+					}
+				}
+				return types.NullValue
+			},
+			expectedSpec: map[string]any{"replicas": int64(1), "paused": true, "ratio": 0.5, "strategy": "Recreate"},
+		},
+		{
+			name: "object",
+			set: func(spec *objectMutator) ref.Val {
+				return spec.SetField("strategy", toRefVal(map[string]any{"type": "Recreate"}))
+			},
+			expectedSpec:  map[string]any{"replicas": int64(1), "strategy": map[string]any{"type": "Recreate"}},
+			expectedPatch: JSONPatch{{Op: PatchOpAdd, Path: "/spec/strategy", Value: map[string]any{"type": "Recreate"}}},
+		},
+		{
+			name:       "type mismatch",
+			withSchema: true,
+			set: func(spec *objectMutator) ref.Val {
+				return spec.SetField("replicas", types.String("3"))
+			},
+			expectedErr: ErrTypeMismatch,
+		},
+		{
+			name:       "unknown field",
+			withSchema: true,
+			set: func(spec *objectMutator) ref.Val {
+				return spec.SetField("ratio", types.Double(0.5))
+			},
+			expectedErr: ErrUnknownField,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := map[string]any{"spec": map[string]any{"replicas": int64(1)}}
+			m := NewRootObjectMutator(root)
+			if tc.withSchema {
+				m = NewRootObjectMutatorWithSchema(root, loadDeploymentSchema(t))
+			}
+			spec := m.(*objectMutator).Get(types.String("spec")).(*objectMutator)
+			result := tc.set(spec)
+			if tc.expectedErr != nil {
+				if !types.IsError(result) || !errors.Is(result.(*types.Err), tc.expectedErr) {
+					t.Fatalf("expected %v but got %v", tc.expectedErr, result)
+				}
+				return
+			}
+			if types.IsError(result) {
+				t.Fatal(result)
+			}
+			if !reflect.DeepEqual(root["spec"], tc.expectedSpec) {
+				t.Errorf("expected %v but got %v", tc.expectedSpec, root["spec"])
+			}
+			if tc.expectedPatch != nil {
+				if patch := JSONPatchOf(m); !reflect.DeepEqual(patch, tc.expectedPatch) {
+					t.Errorf("expected patch %v but got %v", tc.expectedPatch, patch)
+				}
+			}
+		})
+	}
+	if result := NewRootObjectMutator(map[string]any{}).Set(types.NullValue); !types.IsError(result) {
+		t.Errorf("expected error setting the root but got %v", result)
+	}
+}
+
+func TestPatchOperationJSON(t *testing.T) {
+	b, err := json.Marshal(JSONPatch{
+		{Op: PatchOpReplace, Path: "/spec/replicas", Value: nil},
+		{Op: PatchOpRemove, Path: "/spec/paused"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[{"op":"replace","path":"/spec/replicas","value":null},{"op":"remove","path":"/spec/paused"}]`
+	if string(b) != expected {
+		t.Errorf("expected %s but got %s", expected, b)
+	}
+}
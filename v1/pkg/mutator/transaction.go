@@ -0,0 +1,53 @@
+package mutator
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+)
+
+var ErrNotRoot = fmt.Errorf("not a root mutator")
+
+// Transaction makes the changes through a root mutator all-or-nothing.
+// It snapshots the object when it begins, so that the object, the recorded
+// patch and the applied fields can be rolled back to the snapshot.
+type Transaction struct {
+	root     *objectMutator
+	snapshot map[string]any
+	patchLen int
+	applied  *fieldpath.Set
+}
+
+// Begin starts a transaction on the root mutator.
+func Begin(root Interface) (*Transaction, error) {
+	o, ok := root.(*objectMutator)
+	if !ok || o.Parent() != nil || o.recorder == nil {
+		return nil, ErrNotRoot
+	}
+	return &Transaction{
+		root:     o,
+		snapshot: deepCopy(o.object).(map[string]any),
+		patchLen: len(o.recorder.patch),
+		applied:  o.recorder.applied,
+	}, nil
+}
+
+// Rollback restores the object, in place, and the recorded patch to their
+// states when the transaction began. Mutators derived from the root before
+// the rollback refer to the discarded values and must not be used anymore.
+func (t *Transaction) Rollback() {
+	for key := range t.root.object {
+		delete(t.root.object, key)
+	}
+	for key, val := range deepCopy(t.snapshot).(map[string]any) {
+		t.root.object[key] = val
+	}
+	t.root.recorder.patch = t.root.recorder.patch[:t.patchLen]
+	t.root.recorder.applied = t.applied
+}
+
+// Snapshot returns the object as it was when the transaction began. The
+// snapshot must not be modified.
+func (t *Transaction) Snapshot() map[string]any {
+	return t.snapshot
+}
@@ -0,0 +1,62 @@
+package mutator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/cel-go/common/types"
+)
+
+func TestTransactionRollback(t *testing.T) {
+	root := map[string]any{
+		"metadata": map[string]any{"name": "nginx"},
+		"spec": map[string]any{
+			"replicas": int64(1),
+			"args":     []any{"a", "b"},
+		},
+	}
+	original := deepCopy(root)
+	m := NewRootObjectMutator(root)
+	if result := m.Merge(toRefVal(map[string]any{"metadata": map[string]any{"labels": map[string]any{"app": "nginx"}}})); types.IsError(result) {
+		t.Fatal(result)
+	}
+	committed := deepCopy(root)
+	patch := JSONPatchOf(m)
+
+	tx, err := Begin(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := m.(*objectMutator).Get(types.String("spec")).(*objectMutator)
+	if result := spec.SetField("replicas", types.Int(3)); types.IsError(result) {
+		t.Fatal(result)
+	}
+	args := spec.Get(types.String("args")).(Container)
+	if result := args.Merge(toRefVal([]any{"c"})); types.IsError(result) {
+		t.Fatal(result)
+	}
+	if err := args.RemoveChild(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.(Container).RemoveChild("metadata"); err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(root, committed) {
+		t.Fatal("expected the object to be changed")
+	}
+	tx.Rollback()
+	if !reflect.DeepEqual(root, committed) {
+		t.Errorf("expected %v after rollback but got %v", committed, root)
+	}
+	if actual := JSONPatchOf(m); !reflect.DeepEqual(actual, patch) {
+		t.Errorf("expected patch %v after rollback but got %v", patch, actual)
+	}
+	if reflect.DeepEqual(root, original) {
+		t.Error("expected the changes before the transaction to be kept")
+	}
+
+	child := m.(*objectMutator).Get(types.String("spec")).(Interface)
+	if _, err := Begin(child); err != ErrNotRoot {
+		t.Errorf("expected %v but got %v", ErrNotRoot, err)
+	}
+}
@@ -0,0 +1,263 @@
+package openapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+)
+
+const extEmbeddedResource = "x-kubernetes-embedded-resource"
+
+var customResourceDefinitionKind = schema.GroupVersionKind{
+	Group:   "apiextensions.k8s.io",
+	Version: "v1",
+	Kind:    "CustomResourceDefinition",
+}
+
+// CustomResourceDefinition holds the structural schemas of the served
+// versions of a CustomResourceDefinition.
+type CustomResourceDefinition struct {
+	Name  string
+	Group string
+	Kind  string
+
+	// Versions holds the names of the served versions, in the order of the
+	// definition.
+	Versions []string
+
+	// Schemas holds the structural schemas of the served versions by their
+	// names.
+	Schemas map[string]*spec.Schema
+}
+
+// LoadCustomResourceDefinitions decodes the CustomResourceDefinitions of a
+// stream of YAML documents or JSON objects. Every document must be an
+// apiextensions.k8s.io/v1 CustomResourceDefinition.
+func LoadCustomResourceDefinitions(reader io.Reader) ([]*CustomResourceDefinition, error) {
+	var crds []*CustomResourceDefinition
+	d := yaml.NewYAMLOrJSONDecoder(reader, 4096)
+	for {
+		object := make(map[string]any)
+		err := d.Decode(&object)
+		if errors.Is(err, io.EOF) {
+			return crds, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(object) == 0 {
+			continue
+		}
+		crd, err := NewCustomResourceDefinition(object)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", len(crds), err)
+		}
+		crds = append(crds, crd)
+	}
+}
+
+// NewCustomResourceDefinition extracts the structural schemas of the served
+// versions of the CustomResourceDefinition.
+func NewCustomResourceDefinition(object map[string]any) (*CustomResourceDefinition, error) {
+	u := &unstructured.Unstructured{Object: object}
+	if gvk := u.GroupVersionKind(); gvk != customResourceDefinitionKind {
+		return nil, fmt.Errorf("expected a %s but got %s", customResourceDefinitionKind, gvk)
+	}
+	crd := &CustomResourceDefinition{Name: u.GetName(), Schemas: make(map[string]*spec.Schema)}
+	crd.Group, _, _ = unstructured.NestedString(object, "spec", "group")
+	crd.Kind, _, _ = unstructured.NestedString(object, "spec", "names", "kind")
+	if crd.Group == "" || crd.Kind == "" {
+		return nil, fmt.Errorf("%s: missing group or kind", crd.Name)
+	}
+	versions, _, err := unstructured.NestedSlice(object, "spec", "versions")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", crd.Name, err)
+	}
+	for _, v := range versions {
+		version, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected a version but got %T", crd.Name, v)
+		}
+		name, _, _ := unstructured.NestedString(version, "name")
+		if served, _, _ := unstructured.NestedBool(version, "served"); !served {
+			continue
+		}
+		openAPIV3Schema, ok, _ := unstructured.NestedMap(version, "schema", "openAPIV3Schema")
+		if !ok {
+			return nil, fmt.Errorf("%s: version %q: missing schema", crd.Name, name)
+		}
+		s, err := decodeSchema(openAPIV3Schema)
+		if err != nil {
+			return nil, fmt.Errorf("%s: version %q: %w", crd.Name, name, err)
+		}
+		crd.Versions = append(crd.Versions, name)
+		crd.Schemas[name] = structural(s, true)
+	}
+	return crd, nil
+}
+
+// GroupVersionKinds returns the kinds of the served versions.
+func (c *CustomResourceDefinition) GroupVersionKinds() []schema.GroupVersionKind {
+	gvks := make([]schema.GroupVersionKind, 0, len(c.Versions))
+	for _, version := range c.Versions {
+		gvks = append(gvks, schema.GroupVersionKind{Group: c.Group, Version: version, Kind: c.Kind})
+	}
+	return gvks
+}
+
+// AddCustomResourceDefinition adds the schemas of the served versions of the
+// CustomResourceDefinition to the document, named the way kube-apiserver
+// names them, e.g. com.example.v1.Widget for the kind Widget of the group
+// example.com.
+func (d *Document) AddCustomResourceDefinition(crd *CustomResourceDefinition) {
+	for _, gvk := range crd.GroupVersionKinds() {
+		name := reverseGroup(gvk.Group) + "." + gvk.Version + "." + gvk.Kind
+		d.Schemas[name] = crd.Schemas[gvk.Version]
+		d.kinds[gvk] = name
+	}
+}
+
+func reverseGroup(group string) string {
+	parts := strings.Split(group, ".")
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(parts, ".")
+}
+
+func decodeSchema(object map[string]any) (*spec.Schema, error) {
+	b, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	s := new(spec.Schema)
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// structural returns the structural part of the schema: the types, the
+// defaults and the extensions, without value validations such as enum,
+// pattern or the subschemas of allOf, anyOf, oneOf and not. The root and
+// the embedded resources gain apiVersion, kind and metadata, as they do in
+// kube-apiserver.
+func structural(s *spec.Schema, root bool) *spec.Schema {
+	result := &spec.Schema{
+		SchemaProps: spec.SchemaProps{
+			Type:        s.Type,
+			Format:      s.Format,
+			Description: s.Description,
+			Default:     s.Default,
+			Nullable:    s.Nullable,
+			Required:    s.Required,
+		},
+	}
+	if len(s.Extensions) > 0 {
+		result.Extensions = make(spec.Extensions, len(s.Extensions))
+		for k, v := range s.Extensions {
+			result.Extensions[k] = v
+		}
+	}
+	if s.Properties != nil {
+		result.Properties = make(map[string]spec.Schema, len(s.Properties))
+		for name := range s.Properties {
+			p := s.Properties[name]
+			result.Properties[name] = *structural(&p, false)
+		}
+	}
+	if s.Items != nil && s.Items.Schema != nil {
+		result.Items = &spec.SchemaOrArray{Schema: structural(s.Items.Schema, false)}
+	}
+	if s.AdditionalProperties != nil {
+		result.AdditionalProperties = &spec.SchemaOrBool{Allows: s.AdditionalProperties.Allows}
+		if s.AdditionalProperties.Schema != nil {
+			result.AdditionalProperties.Schema = structural(s.AdditionalProperties.Schema, false)
+		}
+	}
+	if embedded, _ := s.Extensions.GetBool(extEmbeddedResource); root || embedded {
+		addObjectMeta(result)
+	}
+	return result
+}
+
+// addObjectMeta adds apiVersion, kind and metadata to the schema of a
+// resource, keeping what the schema specifies for the fields of metadata.
+func addObjectMeta(s *spec.Schema) {
+	if s.Properties == nil {
+		s.Properties = make(map[string]spec.Schema)
+	}
+	for _, name := range []string{"apiVersion", "kind"} {
+		if _, ok := s.Properties[name]; !ok {
+			s.Properties[name] = *spec.StringProperty()
+		}
+	}
+	metadata := objectMeta()
+	for name, p := range s.Properties["metadata"].Properties {
+		metadata.Properties[name] = p
+	}
+	s.Properties["metadata"] = *metadata
+}
+
+// objectMeta returns the schema of the metadata of resources.
+func objectMeta() *spec.Schema {
+	ownerReference := &spec.Schema{SchemaProps: spec.SchemaProps{
+		Type:     []string{"object"},
+		Required: []string{"apiVersion", "kind", "name", "uid"},
+		Properties: map[string]spec.Schema{
+			"apiVersion":         *spec.StringProperty(),
+			"kind":               *spec.StringProperty(),
+			"name":               *spec.StringProperty(),
+			"uid":                *spec.StringProperty(),
+			"controller":         *spec.BoolProperty(),
+			"blockOwnerDeletion": *spec.BoolProperty(),
+		},
+	}}
+	managedFieldsEntry := &spec.Schema{SchemaProps: spec.SchemaProps{
+		Type: []string{"object"},
+		Properties: map[string]spec.Schema{
+			"apiVersion":  *spec.StringProperty(),
+			"fieldsType":  *spec.StringProperty(),
+			"fieldsV1":    *withExtensions(new(spec.Schema).Typed("object", ""), spec.Extensions{extPreserveUnknownFields: true}),
+			"manager":     *spec.StringProperty(),
+			"operation":   *spec.StringProperty(),
+			"subresource": *spec.StringProperty(),
+			"time":        *spec.DateTimeProperty(),
+		},
+	}}
+	return &spec.Schema{SchemaProps: spec.SchemaProps{
+		Type: []string{"object"},
+		Properties: map[string]spec.Schema{
+			"annotations":                *spec.MapProperty(spec.StringProperty()),
+			"creationTimestamp":          *spec.DateTimeProperty(),
+			"deletionGracePeriodSeconds": *spec.Int64Property(),
+			"deletionTimestamp":          *spec.DateTimeProperty(),
+			"finalizers":                 *withExtensions(spec.ArrayProperty(spec.StringProperty()), spec.Extensions{"x-kubernetes-list-type": "set"}),
+			"generateName":               *spec.StringProperty(),
+			"generation":                 *spec.Int64Property(),
+			"labels":                     *spec.MapProperty(spec.StringProperty()),
+			"managedFields":              *spec.ArrayProperty(managedFieldsEntry),
+			"name":                       *spec.StringProperty(),
+			"namespace":                  *spec.StringProperty(),
+			"ownerReferences": *withExtensions(spec.ArrayProperty(ownerReference), spec.Extensions{
+				"x-kubernetes-list-type":     "map",
+				"x-kubernetes-list-map-keys": []any{"uid"},
+			}),
+			"resourceVersion": *spec.StringProperty(),
+			"selfLink":        *spec.StringProperty(),
+			"uid":             *spec.StringProperty(),
+		},
+	}}
+}
+
+func withExtensions(s *spec.Schema, extensions spec.Extensions) *spec.Schema {
+	s.Extensions = extensions
+	return s
+}
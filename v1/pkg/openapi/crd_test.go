@@ -0,0 +1,113 @@
+package openapi
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/apply"
+)
+
+const crdFileName = "../../testdata/customresource/crd.yaml"
+
+var widgetKind = schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}
+
+func TestLoadCustomResourceDefinitions(t *testing.T) {
+	f, err := os.Open(crdFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	crds, err := LoadCustomResourceDefinitions(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crds) != 1 {
+		t.Fatalf("expected 1 CustomResourceDefinition but got %d", len(crds))
+	}
+	crd := crds[0]
+	if kinds := crd.GroupVersionKinds(); !reflect.DeepEqual(kinds, []schema.GroupVersionKind{widgetKind}) {
+		t.Errorf("expected only the served version but got %v", kinds)
+	}
+	s := crd.Schemas["v1"]
+	for _, name := range []string{"apiVersion", "kind", "metadata"} {
+		if _, ok := s.Properties[name]; !ok {
+			t.Errorf("missing %s of the resource", name)
+		}
+	}
+	widgetSpec := s.Properties["spec"]
+	if replicas := widgetSpec.Properties["replicas"]; replicas.Minimum != nil {
+		t.Errorf("unexpected value validation of replicas: %v", *replicas.Minimum)
+	}
+	port := widgetSpec.Properties["port"]
+	if intOrString, _ := port.Extensions.GetBool("x-kubernetes-int-or-string"); !intOrString || port.AnyOf != nil {
+		t.Errorf("unexpected schema of port: %v", port)
+	}
+	template := widgetSpec.Properties["template"]
+	if _, ok := template.Properties["metadata"].Properties["labels"]; !ok {
+		t.Errorf("expected the embedded resource to have metadata, but got %v", template.Properties)
+	}
+
+	if _, err := LoadCustomResourceDefinitions(strings.NewReader("apiVersion: v1\nkind: ConfigMap\n")); err == nil {
+		t.Errorf("expected an error on a ConfigMap")
+	}
+}
+
+func TestCustomResourceObjectType(t *testing.T) {
+	d, err := LoadDocumentFiles(crdFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := d.SchemaName(widgetKind); name != "com.example.v1.Widget" {
+		t.Errorf("unexpected schema name %q", name)
+	}
+	s, err := d.SchemaFor(widgetKind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ty, err := apply.CreateObjectType(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name        string
+		object      string
+		expectedErr bool
+	}{
+		{
+			name: "valid",
+			object: `
+metadata: {name: widget, labels: {app: widget}}
+spec:
+  port: http
+  config: {anything: [1, 2]}
+  endpoints: [{name: api}]
+  template: {apiVersion: v1, kind: ConfigMap, metadata: {name: config}, data: {key: value}}`,
+		},
+		{
+			name:        "unknown field",
+			object:      `spec: {unknown: true}`,
+			expectedErr: true,
+		},
+		{
+			name:        "duplicate key",
+			object:      `spec: {endpoints: [{name: api}, {name: api}]}`,
+			expectedErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			object := make(map[string]any)
+			if err := yaml.Unmarshal([]byte(tc.object), &object); err != nil {
+				t.Fatal(err)
+			}
+			_, err := ty.FromUnstructured(object)
+			if (err != nil) != tc.expectedErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package openapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/apply"
+)
+
+var ErrKindNotFound = errors.New("kind not found")
+
+const extGroupVersionKind = "x-kubernetes-group-version-kind"
+const extPreserveUnknownFields = "x-kubernetes-preserve-unknown-fields"
+
+// Document holds the schemas of one or more OpenAPI v3 documents, such as
+// the ones kube-apiserver serves at /openapi/v3/apis/<group>/<version>,
+// indexed by the kinds they describe.
+type Document struct {
+	// Schemas holds the schemas of the components by their names.
+	Schemas map[string]*spec.Schema
+
+	kinds map[schema.GroupVersionKind]string
+}
+
+// NewDocument creates an empty document.
+func NewDocument() *Document {
+	return &Document{
+		Schemas: make(map[string]*spec.Schema),
+		kinds:   make(map[schema.GroupVersionKind]string),
+	}
+}
+
+// LoadDocument decodes an OpenAPI v3 document. Only the schemas of its
+// components are kept; the paths are ignored.
+func LoadDocument(reader io.Reader) (*Document, error) {
+	d := NewDocument()
+	if err := d.Load(reader); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// LoadDocumentFiles loads the given files, or the files in the given
+// directories, into one document. JSON files are OpenAPI v3 documents, and
+// YAML files are CustomResourceDefinitions.
+func LoadDocumentFiles(paths ...string) (*Document, error) {
+	d := NewDocument()
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		files := []string{path}
+		if info.IsDir() {
+			if files, err = documentFilesIn(path); err != nil {
+				return nil, err
+			}
+		}
+		for _, file := range files {
+			if err := d.loadFile(file); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return d, nil
+}
+
+func documentFilesIn(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".json", ".yaml", ".yml":
+			if !entry.IsDir() {
+				files = append(files, filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+func (d *Document) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		crds, err := LoadCustomResourceDefinitions(f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for _, crd := range crds {
+			d.AddCustomResourceDefinition(crd)
+		}
+	default:
+		if err := d.Load(f); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// Load decodes an OpenAPI v3 document and adds its schemas to the document.
+// A schema replaces any existing one of the same name, and so does a kind.
+func (d *Document) Load(reader io.Reader) error {
+	var doc struct {
+		OpenAPI    string `json:"openapi"`
+		Components struct {
+			Schemas map[string]*spec.Schema `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(reader).Decode(&doc); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return fmt.Errorf("not an OpenAPI v3 document: openapi: %q", doc.OpenAPI)
+	}
+	for name, s := range doc.Components.Schemas {
+		d.Schemas[name] = s
+		gvks, err := groupVersionKindsOf(s)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		for _, gvk := range gvks {
+			d.kinds[gvk] = name
+		}
+	}
+	return nil
+}
+
+// Kinds returns the kinds that the document describes, sorted.
+func (d *Document) Kinds() []schema.GroupVersionKind {
+	kinds := make([]schema.GroupVersionKind, 0, len(d.kinds))
+	for gvk := range d.kinds {
+		kinds = append(kinds, gvk)
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		return kinds[i].String() < kinds[j].String()
+	})
+	return kinds
+}
+
+// SchemaName returns the name of the schema of the kind.
+func (d *Document) SchemaName(gvk schema.GroupVersionKind) (string, bool) {
+	name, ok := d.kinds[gvk]
+	return name, ok
+}
+
+// SchemaFor returns the schema of the kind with all references resolved, so
+// that it can be used wherever a self-contained schema is expected, such as
+// by mutators and apply.CreateObjectType. The subschemas of allOf are merged
+// into their parents. A reference back to a schema that is being resolved is
+// replaced by a schema that preserves unknown fields. See
+// apply.SchemaTracker.Resolve.
+func (d *Document) SchemaFor(gvk schema.GroupVersionKind) (*spec.Schema, error) {
+	name, ok := d.kinds[gvk]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKindNotFound, gvk)
+	}
+	return d.Resolve(apply.ComponentRef(name))
+}
+
+// SchemaOf is like SchemaFor, but returns nil for a kind that the document
+// does not describe.
+func (d *Document) SchemaOf(gvk schema.GroupVersionKind) (*spec.Schema, error) {
+	if _, ok := d.kinds[gvk]; !ok {
+		return nil, nil
+	}
+	return d.SchemaFor(gvk)
+}
+
+// Resolve returns a copy of the schema with all references to the schemas
+// of the document resolved. See SchemaFor.
+func (d *Document) Resolve(s *spec.Schema) (*spec.Schema, error) {
+	return d.Tracker(s).Resolve()
+}
+
+// Tracker returns a tracker at the schema, which resolves the references to
+// the schemas of the document as it advances.
+func (d *Document) Tracker(s *spec.Schema) *apply.SchemaTracker {
+	return apply.NewSchemaTracker(s, d.Schemas)
+}
+
+func groupVersionKindsOf(s *spec.Schema) ([]schema.GroupVersionKind, error) {
+	v, ok := s.Extensions[extGroupVersionKind]
+	if !ok {
+		return nil, nil
+	}
+	list, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: expected a list but got %T", extGroupVersionKind, v)
+	}
+	var gvks []schema.GroupVersionKind
+	for _, item := range list {
+		m, ok := item.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected an object but got %T", extGroupVersionKind, item)
+		}
+		group, _ := m["group"].(string)
+		version, _ := m["version"].(string)
+		kind, _ := m["kind"].(string)
+		gvks = append(gvks, schema.GroupVersionKind{Group: group, Version: version, Kind: kind})
+	}
+	return gvks, nil
+}
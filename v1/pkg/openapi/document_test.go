@@ -0,0 +1,113 @@
+package openapi
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/apply"
+)
+
+var deploymentKind = schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+
+func loadAppsDocument(t *testing.T) *Document {
+	d, err := LoadDocumentFiles("../../testdata/openapi")
+	if err != nil {
+		t.Fatalf("cannot load document: %v", err)
+	}
+	return d
+}
+
+func TestLoadDocument(t *testing.T) {
+	d := loadAppsDocument(t)
+	name, ok := d.SchemaName(deploymentKind)
+	if !ok || name != "io.k8s.api.apps.v1.Deployment" {
+		t.Errorf("unexpected schema of %s: %q", deploymentKind, name)
+	}
+	// DeleteOptions is shared by all groups.
+	deleteOptions := schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "DeleteOptions"}
+	if name, _ := d.SchemaName(deleteOptions); name != "io.k8s.apimachinery.pkg.apis.meta.v1.DeleteOptions" {
+		t.Errorf("unexpected schema of %s: %q", deleteOptions, name)
+	}
+	if len(d.Kinds()) < 2 {
+		t.Errorf("unexpected kinds: %v", d.Kinds())
+	}
+	if _, err := d.SchemaFor(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Missing"}); !errors.Is(err, ErrKindNotFound) {
+		t.Errorf("expected ErrKindNotFound but got %v", err)
+	}
+	if _, err := LoadDocument(strings.NewReader(`{"swagger": "2.0"}`)); err == nil {
+		t.Errorf("expected an error on an OpenAPI v2 document")
+	}
+}
+
+func TestSchemaFor(t *testing.T) {
+	d := loadAppsDocument(t)
+	s, err := d.SchemaFor(deploymentKind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var walk func(path string, s *spec.Schema)
+	walk = func(path string, s *spec.Schema) {
+		if s.Ref.String() != "" || len(s.AllOf) > 0 {
+			t.Errorf("%s: unresolved schema", path)
+		}
+		for name, p := range s.Properties {
+			walk(path+"."+name, &p)
+		}
+		if s.Items != nil && s.Items.Schema != nil {
+			walk(path+"[]", s.Items.Schema)
+		}
+		if s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+			walk(path+"{}", s.AdditionalProperties.Schema)
+		}
+	}
+	walk("", s)
+
+	containers := s.Properties["spec"].Properties["template"].Properties["spec"].Properties["containers"]
+	if key, _ := containers.Extensions.GetString("x-kubernetes-patch-merge-key"); key != "name" {
+		t.Errorf("unexpected patch merge key of containers: %q", key)
+	}
+	if name := containers.Items.Schema.Properties["name"]; len(name.Type) != 1 || name.Type[0] != "string" {
+		t.Errorf("unexpected schema of container names: %v", name.Type)
+	}
+	maxSurge := s.Properties["spec"].Properties["strategy"].Properties["rollingUpdate"].Properties["maxSurge"]
+	if maxSurge.Format != "int-or-string" {
+		t.Errorf("unexpected format of maxSurge: %q", maxSurge.Format)
+	}
+	if s.Properties["metadata"].Properties["labels"].AdditionalProperties == nil {
+		t.Errorf("expected the labels to be a map")
+	}
+}
+
+func TestResolveCycle(t *testing.T) {
+	d, err := LoadDocument(strings.NewReader(`{
+		"openapi": "3.0.0",
+		"components": {"schemas": {
+			"Node": {
+				"type": "object",
+				"properties": {
+					"value": {"type": "string"},
+					"children": {"type": "array", "items": {"$ref": "#/components/schemas/Node"}}
+				},
+				"x-kubernetes-group-version-kind": [{"group": "example.com", "version": "v1", "kind": "Node"}]
+			}
+		}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := d.SchemaFor(schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Node"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := s.Properties["children"].Items.Schema
+	if preserve, _ := child.Extensions.GetBool(extPreserveUnknownFields); !preserve {
+		t.Errorf("expected the cyclic reference to preserve unknown fields, but got %v", child)
+	}
+	if _, err := d.Resolve(apply.ComponentRef("Missing")); !errors.Is(err, apply.ErrUnresolvedRef) {
+		t.Errorf("expected ErrUnresolvedRef but got %v", err)
+	}
+}
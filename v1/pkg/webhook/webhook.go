@@ -0,0 +1,205 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/admission"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/api"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/matcher"
+)
+
+// maxRequestBodyBytes follows the limit that kube-apiserver puts on
+// admission requests.
+const maxRequestBodyBytes = 3 * 1024 * 1024
+
+// Webhook serves admission.k8s.io/v1 AdmissionReview requests by applying
+// the matching MutatingAdmissionPolicies to the incoming objects.
+type Webhook struct {
+	policies        *admission.KindPolicies
+	equivalents     runtime.EquivalentResourceMapper
+	namespaceLabels NamespaceLabelsFunc
+	schemaOf        admission.SchemaFunc
+}
+
+// NamespaceLabelsFunc looks up the labels of a namespace.
+type NamespaceLabelsFunc func(namespace string) (map[string]string, error)
+
+// Option configures a Webhook.
+type Option func(*Webhook)
+
+// WithEquivalentResources sets the mapper that finds equivalent resources
+// for policies with matchPolicy: Equivalent.
+func WithEquivalentResources(equivalents runtime.EquivalentResourceMapper) Option {
+	return func(w *Webhook) {
+		w.equivalents = equivalents
+	}
+}
+
+// WithNamespaceLabels sets the function to look up namespace labels for
+// namespaceSelector. Without it, namespaces are considered to have no
+// labels.
+func WithNamespaceLabels(namespaceLabels NamespaceLabelsFunc) Option {
+	return func(w *Webhook) {
+		w.namespaceLabels = namespaceLabels
+	}
+}
+
+// WithSchema compiles the policies against the schema of the objects they
+// mutate, so that, e.g., lists are merged according to their types. See
+// admission.WithSchema.
+func WithSchema(s *spec.Schema) Option {
+	return WithSchemas(func(schema.GroupVersionKind) (*spec.Schema, error) {
+		return s, nil
+	})
+}
+
+// WithSchemas compiles the policies, for each kind of objects in the
+// requests, against the schema of the kind.
+func WithSchemas(schemaOf admission.SchemaFunc) Option {
+	return func(w *Webhook) {
+		w.schemaOf = schemaOf
+	}
+}
+
+// NewWebhook compiles the given policies.
+func NewWebhook(policies []*api.MutatingAdmissionPolicy, opts ...Option) (*Webhook, error) {
+	w := new(Webhook)
+	for _, opt := range opts {
+		opt(w)
+	}
+	compiled, err := admission.NewKindPolicies(policies, w.schemaOf, admission.WithEquivalentResources(w.equivalents))
+	if err != nil {
+		return nil, err
+	}
+	w.policies = compiled
+	return w, nil
+}
+
+func (w *Webhook) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(rw, "only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if contentType := req.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+		http.Error(rw, fmt.Sprintf("unsupported content type %q", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxRequestBodyBytes))
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("cannot read request: %v", err), http.StatusBadRequest)
+		return
+	}
+	review := new(admissionv1.AdmissionReview)
+	if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+		http.Error(rw, fmt.Sprintf("malformed AdmissionReview: %v", err), http.StatusBadRequest)
+		return
+	}
+	response := w.Review(review.Request)
+	response.UID = review.Request.UID
+	out := &admissionv1.AdmissionReview{
+		TypeMeta: review.TypeMeta,
+		Response: response,
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(out); err != nil {
+		http.Error(rw, fmt.Sprintf("cannot encode response: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// Review applies the matching policies, in order, to the object of the
+// request. A policy that fails is ignored if its failure policy is Ignore,
+// leaving the object as if the policy has not run. Otherwise, the request
+// is denied. The fields that the policies take over from other field
+// managers are returned as warnings.
+func (w *Webhook) Review(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	response := &admissionv1.AdmissionResponse{UID: req.UID, Allowed: true}
+	if len(req.Object.Raw) == 0 {
+		return response
+	}
+	object := make(map[string]any)
+	if err := utiljson.Unmarshal(req.Object.Raw, &object); err != nil {
+		return deny(http.StatusBadRequest, fmt.Errorf("cannot decode object: %w", err))
+	}
+	attr, err := w.attributesOf(req, object)
+	if err != nil {
+		return deny(http.StatusInternalServerError, err)
+	}
+	policies, err := w.policies.For(schema.GroupVersionKind(req.Kind))
+	if err != nil {
+		return deny(http.StatusInternalServerError, err)
+	}
+	result, err := policies.Admit(object, attr)
+	if err != nil {
+		return deny(http.StatusInternalServerError, err)
+	}
+	if len(result.Patch) > 0 {
+		b, err := json.Marshal(result.Patch)
+		if err != nil {
+			return deny(http.StatusInternalServerError, fmt.Errorf("cannot encode patch: %w", err))
+		}
+		patchType := admissionv1.PatchTypeJSONPatch
+		response.Patch = b
+		response.PatchType = &patchType
+	}
+	for _, c := range result.Conflicts {
+		response.Warnings = append(response.Warnings, c.String())
+	}
+	return response
+}
+
+func deny(code int32, err error) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    code,
+			Message: err.Error(),
+		},
+	}
+}
+
+// attributesOf collects what the matcher needs to know about the request.
+func (w *Webhook) attributesOf(req *admissionv1.AdmissionRequest, object map[string]any) (*matcher.Attributes, error) {
+	attr := &matcher.Attributes{
+		Resource:     schema.GroupVersionResource(req.Resource),
+		SubResource:  req.SubResource,
+		Operation:    api.OperationType(req.Operation),
+		Name:         req.Name,
+		Namespace:    req.Namespace,
+		ObjectLabels: labelsOf(object),
+	}
+	if len(req.OldObject.Raw) > 0 {
+		oldObject := make(map[string]any)
+		if err := utiljson.Unmarshal(req.OldObject.Raw, &oldObject); err != nil {
+			return nil, fmt.Errorf("cannot decode old object: %w", err)
+		}
+		attr.OldObjectLabels = labelsOf(oldObject)
+	}
+	if req.Resource.Group == "" && req.Resource.Resource == "namespaces" {
+		attr.NamespaceLabels = labelsOf(object)
+	} else if req.Namespace != "" && w.namespaceLabels != nil {
+		namespaceLabels, err := w.namespaceLabels(req.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get labels of namespace %q: %w", req.Namespace, err)
+		}
+		attr.NamespaceLabels = namespaceLabels
+	}
+	return attr, nil
+}
+
+func labelsOf(object map[string]any) map[string]string {
+	labels, _, _ := unstructured.NestedStringMap(object, "metadata", "labels")
+	return labels
+}
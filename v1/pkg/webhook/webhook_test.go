@@ -0,0 +1,284 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/api"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/mutator"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/openapi"
+)
+
+func loadPolicy(t *testing.T, fileName string) *api.MutatingAdmissionPolicy {
+	f, err := os.Open(fileName)
+	if err != nil {
+		t.Fatalf("cannot load policy: %v", err)
+	}
+	defer f.Close()
+	policies, err := api.LoadPolicies(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policies[0]
+}
+
+func newRequest(t *testing.T, resource string, operation admissionv1.Operation) *admissionv1.AdmissionReview {
+	return newRequestOf(t, "../../testdata/listmerge/deploy.yaml", resource, operation)
+}
+
+func newRequestOf(t *testing.T, fileName, resource string, operation admissionv1.Operation) *admissionv1.AdmissionReview {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("cannot load object: %v", err)
+	}
+	raw, err := yaml.ToJSON(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       types.UID("705ab4f5-6393-11e8-b7cc-42010a800002"),
+			Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+			Resource:  metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: resource},
+			Operation: operation,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+}
+
+func review(t *testing.T, server *httptest.Server, request *admissionv1.AdmissionReview) *admissionv1.AdmissionReview {
+	b, err := json.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := server.Client().Post(server.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %s", resp.Status)
+	}
+	response := new(admissionv1.AdmissionReview)
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Response.UID != request.Request.UID {
+		t.Errorf("unexpected UID: %q", response.Response.UID)
+	}
+	return response
+}
+
+func TestWebhook(t *testing.T) {
+	policy := loadPolicy(t, "../../testdata/listmerge/mutation.yaml")
+	failing := policy.DeepCopy()
+	failing.Name = "failing"
+	failing.Spec.Mutation[0].Expressions = []string{"object.spec.strategy.remove()"}
+	ignore := api.Ignore
+	ignored := failing.DeepCopy()
+	ignored.Spec.FailurePolicy = &ignore
+	selective := policy.DeepCopy()
+	selective.Spec.MatchConstraints.ObjectSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "redis"}}
+
+	for _, tc := range []struct {
+		name            string
+		policies        []*api.MutatingAdmissionPolicy
+		resource        string
+		operation       admissionv1.Operation
+		expectedAllowed bool
+		expectedPatch   mutator.JSONPatch
+	}{
+		{
+			name:            "mutated",
+			policies:        []*api.MutatingAdmissionPolicy{policy},
+			resource:        "deployments",
+			operation:       admissionv1.Create,
+			expectedAllowed: true,
+			expectedPatch: mutator.JSONPatch{{
+				Op:    mutator.PatchOpAdd,
+				Path:  "/spec/template/spec/containers/1",
+				Value: map[string]any{"name": "sidecar", "image": "cr.example.com/sidecar"},
+			}},
+		},
+		{
+			name:            "not matching resource",
+			policies:        []*api.MutatingAdmissionPolicy{policy},
+			resource:        "replicasets",
+			operation:       admissionv1.Create,
+			expectedAllowed: true,
+		},
+		{
+			name:            "not matching operation",
+			policies:        []*api.MutatingAdmissionPolicy{policy},
+			resource:        "deployments",
+			operation:       admissionv1.Connect,
+			expectedAllowed: true,
+		},
+		{
+			name:            "not matching object selector",
+			policies:        []*api.MutatingAdmissionPolicy{selective},
+			resource:        "deployments",
+			operation:       admissionv1.Create,
+			expectedAllowed: true,
+		},
+		{
+			name:            "failure policy Fail",
+			policies:        []*api.MutatingAdmissionPolicy{policy, failing},
+			resource:        "deployments",
+			operation:       admissionv1.Update,
+			expectedAllowed: false,
+		},
+		{
+			name:            "failure policy Ignore",
+			policies:        []*api.MutatingAdmissionPolicy{ignored, policy},
+			resource:        "deployments",
+			operation:       admissionv1.Update,
+			expectedAllowed: true,
+			expectedPatch: mutator.JSONPatch{{
+				Op:    mutator.PatchOpAdd,
+				Path:  "/spec/template/spec/containers/1",
+				Value: map[string]any{"name": "sidecar", "image": "cr.example.com/sidecar"},
+			}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w, err := NewWebhook(tc.policies)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := httptest.NewTLSServer(w)
+			defer server.Close()
+			response := review(t, server, newRequest(t, tc.resource, tc.operation)).Response
+			if response.Allowed != tc.expectedAllowed {
+				t.Fatalf("expected allowed to be %v but got %v: %v", tc.expectedAllowed, response.Allowed, response.Result)
+			}
+			if len(tc.expectedPatch) == 0 {
+				if response.Patch != nil {
+					t.Errorf("unexpected patch: %s", response.Patch)
+				}
+				return
+			}
+			if response.PatchType == nil || *response.PatchType != admissionv1.PatchTypeJSONPatch {
+				t.Errorf("unexpected patch type: %v", response.PatchType)
+			}
+			var patch mutator.JSONPatch
+			if err := json.Unmarshal(response.Patch, &patch); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(patch, tc.expectedPatch) {
+				t.Errorf("expected patch %v but got %v", tc.expectedPatch, patch)
+			}
+		})
+	}
+}
+
+// TestWebhookSchema updates an object that the policy has already mutated,
+// which the policy leaves as is only if it knows containers are keyed by
+// their names.
+func TestWebhookSchema(t *testing.T) {
+	policy := loadPolicy(t, "../../testdata/listmerge/mutation.yaml")
+	f, err := os.Open("../../testdata/deploy.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s, err := openapi.LoadSchema(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name          string
+		opts          []Option
+		expectedPatch bool
+	}{
+		{name: "without schema", expectedPatch: true},
+		{name: "with schema", opts: []Option{WithSchema(s)}},
+		{
+			name: "with schema of another kind",
+			opts: []Option{WithSchemas(func(gvk schema.GroupVersionKind) (*spec.Schema, error) {
+				if gvk.Kind == "Deployment" {
+					return nil, nil
+				}
+				return s, nil
+			})},
+			expectedPatch: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w, err := NewWebhook([]*api.MutatingAdmissionPolicy{policy}, tc.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := httptest.NewTLSServer(w)
+			defer server.Close()
+			request := newRequestOf(t, "../../testdata/listmerge/expected.yaml", "deployments", admissionv1.Update)
+			response := review(t, server, request).Response
+			if !response.Allowed {
+				t.Fatalf("unexpected denial: %v", response.Result)
+			}
+			if (response.Patch != nil) != tc.expectedPatch {
+				t.Errorf("unexpected patch: %s", response.Patch)
+			}
+		})
+	}
+}
+
+// TestWebhookConflicts applies a policy that takes over a field from
+// another field manager, which is warned about.
+func TestWebhookConflicts(t *testing.T) {
+	policy := loadPolicy(t, "../../testdata/managedfields/mutation.yaml")
+	w, err := NewWebhook([]*api.MutatingAdmissionPolicy{policy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewTLSServer(w)
+	defer server.Close()
+	request := newRequestOf(t, "../../testdata/managedfields/deploy.yaml", "deployments", admissionv1.Update)
+	response := review(t, server, request).Response
+	if !response.Allowed {
+		t.Fatalf("unexpected denial: %v", response.Result)
+	}
+	expected := []string{`policy "managed-fields.policy.example.com": conflict with "kubectl": .spec.replicas`}
+	if !reflect.DeepEqual(response.Warnings, expected) {
+		t.Errorf("expected warnings %q but got %q", expected, response.Warnings)
+	}
+}
+
+func TestWebhookBadRequest(t *testing.T) {
+	w, err := NewWebhook(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewTLSServer(w)
+	defer server.Close()
+	resp, err := server.Client().Post(server.URL, "application/json", bytes.NewReader([]byte("{}")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status: %s", resp.Status)
+	}
+	resp, err = server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status: %s", resp.Status)
+	}
+}
@@ -1,26 +1,24 @@
 package integration
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"reflect"
 	"testing"
 
-	"github.com/google/cel-go/cel"
-	"github.com/google/cel-go/common/types/ref"
-	"github.com/google/cel-go/interpreter"
+	jsonpatch "github.com/evanphx/json-patch"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/yaml"
-
-	"k8s.io/apimachinery/pkg/util/version"
-	apiservercel "k8s.io/apiserver/pkg/cel"
-	"k8s.io/apiserver/pkg/cel/environment"
-	"k8s.io/apiserver/pkg/cel/lazy"
+	"k8s.io/kube-openapi/pkg/validation/spec"
 
 	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/api"
-	mutatorcel "github.com/jiahuif/cel-mutating-experiments/v1/pkg/cel"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/evaluator"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/golden"
 	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/mutator"
+	"github.com/jiahuif/cel-mutating-experiments/v1/pkg/openapi"
 )
 
 func unmarshallTestData(t *testing.T, fileName string, v any) {
@@ -55,113 +53,213 @@ func guessTestDataFileNames(baseName string) (deploy, mutation, expected string,
 	return "", "", "", fmt.Errorf("unable to locate test data for %q", baseName)
 }
 
-func runTestFromFile(t *testing.T, baseName string) {
+func loadSchema(t *testing.T) *spec.Schema {
+	f, err := os.Open("../../testdata/deploy.schema.json")
+	if err != nil {
+		t.Fatalf("cannot load schema file: %v", err)
+	}
+	defer f.Close()
+	s, err := openapi.LoadSchema(f)
+	if err != nil {
+		t.Fatalf("cannot load schema: %v", err)
+	}
+	return s
+}
+
+// runTestFromFile runs the test case against the schema of deployments.
+func runTestFromFile(t *testing.T, baseName string) *evaluator.Result {
+	return runTestFromFileWith(t, baseName, evaluator.WithSchema(loadSchema(t)))
+}
+
+// runTestFromFileWithAndWithoutSchema runs the test case both without any
+// schema and against the schema of deployments, for the cases that do not
+// depend on the schema.
+func runTestFromFileWithAndWithoutSchema(t *testing.T, baseName string) {
+	t.Run("schemaless", func(t *testing.T) {
+		runTestFromFileWith(t, baseName)
+	})
+	t.Run("schema", func(t *testing.T) {
+		runTestFromFile(t, baseName)
+	})
+}
+
+func runTestFromFileWith(t *testing.T, baseName string, opts ...evaluator.Option) *evaluator.Result {
 	deployFileName, mutationFileName, expectedFileName, err := guessTestDataFileNames(baseName)
 	if err != nil {
 		t.Fatalf("missing input for test case %q", baseName)
 	}
-	variables := lazy.NewMapValue(variablesType)
 	deploy := new(unstructured.Unstructured)
 	unmarshallTestData(t, deployFileName, deploy)
-	rootObjectMutator := mutator.NewRootObjectMutator(deploy.Object)
+	original := deploy.DeepCopy()
 	mutation := new(api.MutatingAdmissionPolicy)
 	unmarshallTestData(t, mutationFileName, mutation)
 	expectedDeploy := new(unstructured.Unstructured)
 	unmarshallTestData(t, expectedFileName, expectedDeploy)
-	activation := &testActivation{
-		variables: variables,
-		object:    rootObjectMutator,
-	}
-	env, err := buildTestEnv()
+	e, err := evaluator.NewPolicyEvaluator(mutation, opts...)
 	if err != nil {
 		t.Fatal(err)
 	}
-	for _, m := range mutation.Spec.Mutation {
-		for _, e := range m.Expressions {
-			_, err := compileAndRun(env, activation, e)
-			if err != nil {
-				t.Fatalf("fail to eval: %v", err)
-			}
-		}
+	result, err := e.Apply(deploy.Object)
+	if err != nil {
+		t.Fatalf("fail to eval: %v", err)
 	}
 	if !reflect.DeepEqual(deploy, expectedDeploy) {
 		t.Errorf("wrong result, expected\n%v\n but got \n%v\n", expectedDeploy, deploy.Object)
 	}
+	patched, err := applyJSONPatch(original.Object, result.Patch)
+	if err != nil {
+		t.Fatalf("fail to apply patch %v: %v", result.Patch, err)
+	}
+	if !reflect.DeepEqual(patched, toJSONValue(t, expectedDeploy.Object)) {
+		t.Errorf("wrong patch %v, expected\n%v\n but got \n%v\n", result.Patch, expectedDeploy.Object, patched)
+	}
+	return result
 }
 
 func TestSimpleMerge(t *testing.T) {
-	runTestFromFile(t, "simplemerge")
+	runTestFromFileWithAndWithoutSchema(t, "simplemerge")
 }
 
 func TestSimpleRemove(t *testing.T) {
-	runTestFromFile(t, "simpleremove")
+	runTestFromFileWithAndWithoutSchema(t, "simpleremove")
 }
 
 func TestObjectMerge(t *testing.T) {
-	runTestFromFile(t, "objectmerge")
+	runTestFromFileWithAndWithoutSchema(t, "objectmerge")
+}
+
+func TestDeepMerge(t *testing.T) {
+	runTestFromFileWithAndWithoutSchema(t, "deepmerge")
+}
+
+func TestEnsure(t *testing.T) {
+	runTestFromFileWithAndWithoutSchema(t, "ensure")
 }
 
 func TestListMerge(t *testing.T) {
-	runTestFromFile(t, "listmerge")
+	runTestFromFileWithAndWithoutSchema(t, "listmerge")
+}
+
+func TestListUpsert(t *testing.T) {
+	runTestFromFile(t, "listupsert")
+}
+
+func TestListRemove(t *testing.T) {
+	runTestFromFileWithAndWithoutSchema(t, "listremove")
 }
 
-type testActivation struct {
-	variables *lazy.MapValue
-	object    any
+func TestScalarSet(t *testing.T) {
+	runTestFromFileWithAndWithoutSchema(t, "scalarset")
 }
 
-func compileAndRun(env *cel.Env, activation *testActivation, exp string) (ref.Val, error) {
-	ast, issues := env.Compile(exp)
-	if issues != nil {
-		return nil, fmt.Errorf("fail to compile: %v", issues)
+func TestReadValues(t *testing.T) {
+	runTestFromFileWithAndWithoutSchema(t, "readvalues")
+}
+
+func TestOptionalFields(t *testing.T) {
+	runTestFromFileWithAndWithoutSchema(t, "optionalfields")
+}
+
+func TestSSAApply(t *testing.T) {
+	runTestFromFile(t, "ssaapply")
+}
+
+func TestManagedFields(t *testing.T) {
+	result := runTestFromFile(t, "managedfields")
+	if len(result.Conflicts) != 1 {
+		t.Fatalf("expected 1 conflict but got %v", result.Conflicts)
 	}
-	prog, err := env.Program(ast)
-	if err != nil {
-		return nil, fmt.Errorf("cannot create program: %w", err)
+	if c := result.Conflicts[0]; c.Manager != "kubectl" || c.Path.String() != ".spec.replicas" {
+		t.Errorf("unexpected conflict: %v", c)
 	}
-	v, _, err := prog.Eval(activation)
-	if err != nil {
-		return nil, fmt.Errorf("cannot eval program: %w", err)
+}
+
+func TestConditionSkip(t *testing.T) {
+	result := runTestFromFile(t, "conditionskip")
+	if len(result.Mutations) != 2 {
+		t.Fatalf("expected 2 mutation results but got %d", len(result.Mutations))
+	}
+	if !result.Mutations[0].Skipped {
+		t.Errorf("expected the first mutation to be skipped")
+	}
+	if result.Mutations[1].Skipped {
+		t.Errorf("expected the second mutation to run")
 	}
-	return v, nil
 }
 
-func buildTestEnv() (*cel.Env, error) {
-	envSet, err := environment.MustBaseEnvSet(environment.DefaultCompatibilityVersion()).Extend(
-		environment.VersionedOptions{
-			IntroducedVersion: version.MajorMinor(1, 28),
-			EnvOptions: append([]cel.EnvOption{
-				cel.Variable("variables", variablesType.CelType()),
-				cel.Variable("object", cel.DynType),
-			}, mutatorcel.EnvOpts()...),
-			DeclTypes: []*apiservercel.DeclType{
-				variablesType,
-			},
-		})
+// TestGolden runs all test data through the golden-file runner, so that new
+// cases are covered without a test function of their own.
+func TestGolden(t *testing.T) {
+	runGolden(t, loadSchema(t))
+}
+
+// TestGoldenOpenAPIDocument runs all test data against the schema that the
+// OpenAPI v3 document of apps/v1 has for deployments, which must work the
+// same as the inlined schema.
+func TestGoldenOpenAPIDocument(t *testing.T) {
+	d, err := openapi.LoadDocumentFiles("../../testdata/openapi")
 	if err != nil {
-		return nil, err
+		t.Fatal(err)
+	}
+	s, err := d.SchemaFor(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"})
+	if err != nil {
+		t.Fatal(err)
 	}
-	env, err := envSet.Env(environment.StoredExpressions)
-	return env, err
+	runGolden(t, s)
 }
 
-var variablesType = apiservercel.NewMapType(apiservercel.StringType, apiservercel.AnyType, 0)
-
-func init() {
-	variablesType.Fields = make(map[string]*apiservercel.DeclField)
+func runGolden(t *testing.T, s *spec.Schema) {
+	cases, err := golden.Discover("../../testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner := golden.NewRunner(golden.WithSchema(s))
+	for _, c := range cases {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			result, err := runner.Run(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Passed() {
+				t.Errorf("unexpected output:\n%s", result.Diff)
+			}
+		})
+	}
 }
 
-func (a *testActivation) ResolveName(name string) (any, bool) {
-	switch name {
-	case "object":
-		return a.object, true
-	case "variables":
-		return a.variables, true
-	default:
-		return nil, false
+// applyJSONPatch applies the patch to the object following RFC 6902. The
+// result is decoded from JSON, so it is compared with toJSONValue.
+func applyJSONPatch(object map[string]any, patch mutator.JSONPatch) (any, error) {
+	patchJSON, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := jsonpatch.DecodePatch(patchJSON)
+	if err != nil {
+		return nil, err
+	}
+	objectJSON, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
 	}
+	patched, err := decoded.Apply(objectJSON)
+	if err != nil {
+		return nil, err
+	}
+	var result any
+	return result, json.Unmarshal(patched, &result)
 }
 
-func (a *testActivation) Parent() interpreter.Activation {
-	return nil
+// toJSONValue returns the value as decoded from its JSON encoding.
+func toJSONValue(t *testing.T, v any) any {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result any
+	if err := json.Unmarshal(b, &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
 }